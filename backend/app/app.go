@@ -2,9 +2,12 @@ package app
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
+	"os"
 	"structured-notes/repositories"
 	"structured-notes/services"
+	"structured-notes/storage"
 	"structured-notes/utils"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -20,20 +23,42 @@ type Config struct {
 	}
 	Media struct {
 		MaxSize              float64
+		MaxSizes             map[string]float64 // by type or type family, MaxSize otherwise
 		MaxUploadsSize       float64
 		SupportedTypesImages []string
 		SupportedTypes       []string
+		Storage              string // "local" or "s3"
+		Directory            string // local storage
+		S3                   storage.S3Config
+		ReconcileInterval    int    // hours; 0: disabled
+		UploadsDirectory     string // resumable uploads in progress, always local
+		UploadExpiry         int    // hours
 	}
 	Auth struct {
 		AccessTokenExpiry  int
 		RefreshTokenExpiry int
 	}
+	Versions struct {
+		MaxCount int
+		MaxAge   int
+	}
+	Trash struct {
+		Retention int
+	}
+	Backups struct {
+		Directory  string
+		Interval   int
+		KeepLast   int
+		KeepDaily  int
+		KeepWeekly int
+	}
 }
 
 type App struct {
 	DB        *sql.DB
 	Snowflake *utils.Snowflake
 	Config    Config
+	Storage   storage.Storage
 	Services  *services.ServiceManager
 	Repos     *repositories.RepositoryManager
 }
@@ -53,7 +78,12 @@ func InitApp(config Config) *App {
 	}
 	app.Repos = repoManager
 
-	serviceManager, err := services.NewServiceManager(repoManager, app.Snowflake)
+	app.Storage, err = NewStorage(config)
+	if err != nil {
+		log.Fatalf("Failed to initialize media storage: %v", err)
+	}
+
+	serviceManager, err := services.NewServiceManager(repoManager, app.Storage, app.Snowflake)
 	if err != nil {
 		log.Fatalf("Failed to initialize service manager: %v", err)
 	}
@@ -61,3 +91,20 @@ func InitApp(config Config) *App {
 
 	return &app
 }
+
+// NewStorage returns the media storage selected by the [Media] configuration.
+// S3 credentials are read from S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY.
+func NewStorage(config Config) (storage.Storage, error) {
+	switch config.Media.Storage {
+	case "", "local":
+		directory := config.Media.Directory
+		if directory == "" {
+			directory = "media"
+		}
+		return storage.NewLocalStorage(directory), nil
+	case "s3":
+		return storage.NewS3Storage(config.Media.S3, os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"))
+	default:
+		return nil, fmt.Errorf("unknown media storage %q", config.Media.Storage)
+	}
+}
@@ -0,0 +1,513 @@
+package app
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"structured-notes/logger"
+	"structured-notes/storage"
+	"time"
+)
+
+// InstanceBackupFormatVersion is bumped whenever the layout of instance archives changes
+const InstanceBackupFormatVersion = 1
+
+// instanceTables are dumped in this order, referenced tables first
+var instanceTables = []string{
+	"users",
+	"nodes",
+	"permissions",
+	"sessions",
+	"connections_logs",
+	"node_versions",
+	"tags",
+	"node_tags",
+	"node_links",
+}
+
+const (
+	instanceManifestFile = "manifest.json"
+	instanceTablesFolder = "tables"
+	instanceMediaFolder  = "media"
+)
+
+// InstanceManifest is stored as manifest.json at the root of an instance archive.
+// Tables are stored as tables/<name>.jsonl, a line listing the columns and then a line per row,
+// the files of the media storage under media/<key>.
+type InstanceManifest struct {
+	FormatVersion    int                    `json:"format_version"`
+	SchemaVersion    uint                   `json:"schema_version"`
+	CreatedTimestamp int64                  `json:"created_timestamp"`
+	Tables           []*InstanceArchiveFile `json:"tables"`
+	Media            []*InstanceArchiveFile `json:"media"`
+}
+
+type InstanceArchiveFile struct {
+	Path   string `json:"path"`
+	Rows   int    `json:"rows,omitempty"` // tables only
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupInstance writes every table and the media folder to a ZIP archive at target.
+// Tables are read in a single read-only transaction so that they are consistent with each other.
+func (app *App) BackupInstance(target string) error {
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	if err := app.writeInstanceArchive(file); err != nil {
+		file.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(target)
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+func (app *App) writeInstanceArchive(w io.Writer) error {
+	tx, err := app.DB.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	manifest := &InstanceManifest{
+		FormatVersion:    InstanceBackupFormatVersion,
+		CreatedTimestamp: time.Now().UnixMilli(),
+		Tables:           make([]*InstanceArchiveFile, 0, len(instanceTables)),
+		Media:            make([]*InstanceArchiveFile, 0),
+	}
+	if err := tx.QueryRow("SELECT version FROM schema_migrations LIMIT 1").Scan(&manifest.SchemaVersion); err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+
+	archive := zip.NewWriter(w)
+	for _, table := range instanceTables {
+		entry, err := dumpTable(tx, archive, table)
+		if err != nil {
+			return err
+		}
+		manifest.Tables = append(manifest.Tables, entry)
+		logger.Info(fmt.Sprintf("Dumped %d rows of %s", entry.Rows, table))
+	}
+
+	objects, err := app.Storage.List("")
+	if err != nil {
+		return fmt.Errorf("failed to archive media: %w", err)
+	}
+	for _, object := range objects {
+		media, err := archiveMediaFile(archive, app.Storage, object.Key)
+		if err != nil {
+			return fmt.Errorf("failed to archive media: %w", err)
+		}
+		manifest.Media = append(manifest.Media, media)
+	}
+
+	file, err := archive.Create(instanceManifestFile)
+	if err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return archive.Close()
+}
+
+// dumpTable writes a table as JSON lines, values as strings or null as they come from the text protocol
+func dumpTable(tx *sql.Tx, archive *zip.Writer, table string) (*InstanceArchiveFile, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT * FROM `%s`", table))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns of %s: %w", table, err)
+	}
+
+	entry := &InstanceArchiveFile{Path: path.Join(instanceTablesFolder, table+".jsonl")}
+	file, err := archive.Create(entry.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(file, hash)}
+	encoder := json.NewEncoder(counter)
+
+	if err := encoder.Encode(columns); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", entry.Path, err)
+	}
+
+	values := make([]sql.NullString, len(columns))
+	scanArgs := make([]any, len(columns))
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+	row := make([]*string, len(columns))
+	for rows.Next() {
+		if err := rows.Scan(scanArgs...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", table, err)
+		}
+		for i, value := range values {
+			row[i] = nil
+			if value.Valid {
+				row[i] = &value.String
+			}
+		}
+		if err := encoder.Encode(row); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", entry.Path, err)
+		}
+		entry.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating %s: %w", table, err)
+	}
+
+	entry.Size = counter.n
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, nil
+}
+
+func archiveMediaFile(archive *zip.Writer, store storage.Storage, key string) (*InstanceArchiveFile, error) {
+	src, _, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	entry := &InstanceArchiveFile{Path: path.Join(instanceMediaFolder, key)}
+	// media are compressed already
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: entry.Path, Method: zip.Store})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	if entry.Size, err = io.Copy(io.MultiWriter(file, hash), src); err != nil {
+		return nil, err
+	}
+	entry.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return entry, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// RestoreInstance loads an archive of BackupInstance into the database, which must be migrated and empty,
+// and the media folder. Every file is checked against the manifest before anything is written.
+func (app *App) RestoreInstance(source string) error {
+	reader, err := zip.OpenReader(source)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer reader.Close()
+
+	entries := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		entries[file.Name] = file
+	}
+
+	manifest, err := readInstanceManifest(entries)
+	if err != nil {
+		return err
+	}
+	if err := app.checkInstanceRestore(manifest); err != nil {
+		return err
+	}
+	for _, entry := range append(append([]*InstanceArchiveFile{}, manifest.Tables...), manifest.Media...) {
+		if err := verifyArchiveFile(entries, entry); err != nil {
+			return err
+		}
+	}
+
+	// rows of self-referencing tables such as nodes are not sorted, foreign key checks are off on the connection
+	// of the restore and MySQL does not run them again when they are turned back on: checkOrphans does, before the commit
+	ctx := context.Background()
+	conn, err := app.DB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get a connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 0"); err != nil {
+		return fmt.Errorf("failed to disable foreign key checks: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, entry := range manifest.Tables {
+		table := strings.TrimSuffix(path.Base(entry.Path), ".jsonl")
+		if err := loadTable(tx, entries[entry.Path], table); err != nil {
+			return err
+		}
+		logger.Info(fmt.Sprintf("Restored %d rows of %s", entry.Rows, table))
+	}
+	if err := checkOrphans(tx); err != nil {
+		return err
+	}
+
+	written := make([]string, 0, len(manifest.Media))
+	for _, entry := range manifest.Media {
+		key := strings.TrimPrefix(entry.Path, instanceMediaFolder+"/")
+		if err := extractMediaFile(app.Storage, entries[entry.Path], key); err != nil {
+			app.removeMediaFiles(written)
+			return err
+		}
+		written = append(written, key)
+	}
+
+	if err := tx.Commit(); err != nil {
+		app.removeMediaFiles(written)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func readInstanceManifest(entries map[string]*zip.File) (*InstanceManifest, error) {
+	file, ok := entries[instanceManifestFile]
+	if !ok {
+		return nil, errors.New("invalid archive: manifest.json is missing")
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	defer reader.Close()
+
+	var manifest InstanceManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid archive: manifest.json: %w", err)
+	}
+	return &manifest, nil
+}
+
+// checkInstanceRestore refuses archives this server cannot load and databases holding data already
+func (app *App) checkInstanceRestore(manifest *InstanceManifest) error {
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > InstanceBackupFormatVersion {
+		return fmt.Errorf("unsupported archive format version %d", manifest.FormatVersion)
+	}
+
+	var schemaVersion uint
+	if err := app.DB.QueryRow("SELECT version FROM schema_migrations LIMIT 1").Scan(&schemaVersion); err != nil {
+		return fmt.Errorf("failed to get schema version: %w", err)
+	}
+	if manifest.SchemaVersion != schemaVersion {
+		return fmt.Errorf("archive schema version %d does not match the database schema version %d", manifest.SchemaVersion, schemaVersion)
+	}
+
+	// every table once, an archive missing one would leave it empty without notice
+	seen := make(map[string]bool, len(manifest.Tables))
+	for _, entry := range manifest.Tables {
+		table := strings.TrimSuffix(path.Base(entry.Path), ".jsonl")
+		if path.Dir(entry.Path) != instanceTablesFolder || !isInstanceTable(table) {
+			return fmt.Errorf("invalid archive: unexpected table %s", entry.Path)
+		}
+		if seen[table] {
+			return fmt.Errorf("invalid archive: table %s is listed twice", table)
+		}
+		seen[table] = true
+		var count int
+		if err := app.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM `%s`", table)).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count rows of %s: %w", table, err)
+		}
+		if count > 0 {
+			return fmt.Errorf("table %s is not empty, restores need an empty database", table)
+		}
+	}
+
+	for _, table := range instanceTables {
+		if !seen[table] {
+			return fmt.Errorf("invalid archive: table %s is missing", table)
+		}
+	}
+
+	for _, entry := range manifest.Media {
+		cleaned := path.Clean(entry.Path)
+		if cleaned != entry.Path || !strings.HasPrefix(cleaned, instanceMediaFolder+"/") {
+			return fmt.Errorf("invalid archive: unexpected media file %s", entry.Path)
+		}
+	}
+	return nil
+}
+
+// checkOrphans runs the foreign key checks skipped while loading the tables: every foreign key of the schema,
+// such as nodes.parent_id or permissions.node_id, must point to an existing row
+func checkOrphans(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT TABLE_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME
+		FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME IS NOT NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+	type foreignKey struct{ table, column, refTable, refColumn string }
+	keys := make([]foreignKey, 0)
+	for rows.Next() {
+		var key foreignKey
+		if err := rows.Scan(&key.table, &key.column, &key.refTable, &key.refColumn); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan foreign key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to list foreign keys: %w", err)
+	}
+
+	for _, key := range keys {
+		var orphans int
+		query := fmt.Sprintf("SELECT COUNT(*) FROM `%s` c LEFT JOIN `%s` p ON p.`%s` = c.`%s` WHERE c.`%s` IS NOT NULL AND p.`%s` IS NULL",
+			key.table, key.refTable, key.refColumn, key.column, key.column, key.refColumn)
+		if err := tx.QueryRow(query).Scan(&orphans); err != nil {
+			return fmt.Errorf("failed to check %s.%s: %w", key.table, key.column, err)
+		}
+		if orphans > 0 {
+			return fmt.Errorf("invalid archive: %d rows of %s reference a missing %s through %s", orphans, key.table, key.refTable, key.column)
+		}
+	}
+	return nil
+}
+
+func isInstanceTable(table string) bool {
+	for _, name := range instanceTables {
+		if name == table {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyArchiveFile checks the size and checksum of a file of the archive against the manifest
+func verifyArchiveFile(entries map[string]*zip.File, entry *InstanceArchiveFile) error {
+	file, ok := entries[entry.Path]
+	if !ok {
+		return fmt.Errorf("invalid archive: %s is missing", entry.Path)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %s: %w", entry.Path, err)
+	}
+	if size != entry.Size || hex.EncodeToString(hash.Sum(nil)) != entry.SHA256 {
+		return fmt.Errorf("invalid archive: %s does not match its checksum", entry.Path)
+	}
+	return nil
+}
+
+func loadTable(tx *sql.Tx, file *zip.File, table string) error {
+	reader, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+
+	scanner := bufio.NewScanner(reader)
+	// contents are stored on a single line
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<30)
+
+	if !scanner.Scan() {
+		return fmt.Errorf("invalid archive: %s has no columns", file.Name)
+	}
+	var columns []string
+	if err := json.Unmarshal(scanner.Bytes(), &columns); err != nil {
+		return fmt.Errorf("invalid archive: %s: %w", file.Name, err)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = "`" + strings.ReplaceAll(column, "`", "``") + "`"
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)",
+		table, strings.Join(quoted, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into %s: %w", table, err)
+	}
+	defer stmt.Close()
+
+	for scanner.Scan() {
+		var row []*string
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			return fmt.Errorf("invalid archive: %s: %w", file.Name, err)
+		}
+		if len(row) != len(columns) {
+			return fmt.Errorf("invalid archive: %s has a row of %d values for %d columns", file.Name, len(row), len(columns))
+		}
+		args := make([]any, len(row))
+		for i, value := range row {
+			if value != nil {
+				args[i] = *value
+			}
+		}
+		if _, err := stmt.Exec(args...); err != nil {
+			return fmt.Errorf("failed to restore a row of %s: %w", table, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return nil
+}
+
+// extractMediaFile writes a media file of the archive, existing files are never overwritten
+func extractMediaFile(store storage.Storage, file *zip.File, key string) error {
+	if _, err := store.Stat(key); err == nil {
+		return fmt.Errorf("media file %s already exists", key)
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("failed to check media file: %w", err)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer src.Close()
+
+	if err := store.Put(key, src, int64(file.UncompressedSize64)); err != nil {
+		return fmt.Errorf("failed to write media file: %w", err)
+	}
+	return nil
+}
+
+func (app *App) removeMediaFiles(keys []string) {
+	for _, key := range keys {
+		if err := app.Storage.Delete(key); err != nil {
+			logger.Error(fmt.Sprintf("Error removing media file %s: %v", key, err))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"fmt"
+	"structured-notes/logger"
+	"structured-notes/services"
+	"time"
+)
+
+// Job is a task repeated in the background for as long as the server runs
+type Job struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+func (app *App) StartJobs() {
+	jobs := []Job{
+		{
+			Name:     "trash purge",
+			Interval: time.Hour,
+			Run: func() error {
+				purged, err := app.Services.Node.PurgeTrash(app.Config.Trash.Retention)
+				if purged > 0 {
+					logger.Info(fmt.Sprintf("Purged %d nodes from the trash", purged))
+				}
+				return err
+			},
+		},
+		{
+			// users are backed up once their last backup is older than the configured interval
+			Name:     "scheduled backups",
+			Interval: time.Hour,
+			Run: func() error {
+				created, err := app.Services.Backup.RunScheduledBackups(app.BackupSchedule())
+				if created > 0 {
+					logger.Info(fmt.Sprintf("Created %d scheduled backups", created))
+				}
+				return err
+			},
+		},
+		{
+			Name:     "upload purge",
+			Interval: time.Hour,
+			Run: func() error {
+				purged, err := app.Services.Upload.PurgeUploads(app.UploadArea())
+				if purged > 0 {
+					logger.Info(fmt.Sprintf("Purged %d expired uploads", purged))
+				}
+				return err
+			},
+		},
+	}
+	if app.Config.Media.ReconcileInterval > 0 {
+		jobs = append(jobs, Job{
+			Name:     "media reconciliation",
+			Interval: time.Duration(app.Config.Media.ReconcileInterval) * time.Hour,
+			Run: func() error {
+				report, err := app.Services.Media.ReconcileMedia(false)
+				if err != nil {
+					return err
+				}
+				if report.RemovedFiles > 0 {
+					logger.Info(fmt.Sprintf("Removed %d orphan media files", report.RemovedFiles))
+				}
+				if len(report.MissingFiles) > 0 {
+					logger.Warn(fmt.Sprintf("%d media nodes have no file, run -reconcile-media -dry-run for the list", len(report.MissingFiles)))
+				}
+				return nil
+			},
+		})
+	}
+
+	for _, job := range jobs {
+		go runJob(job)
+	}
+}
+
+func runJob(job Job) {
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job.Run(); err != nil {
+			logger.Error(fmt.Sprintf("Job %s failed: %v", job.Name, err))
+		}
+		<-ticker.C
+	}
+}
+
+func (app *App) BackupSchedule() services.BackupSchedule {
+	return services.BackupSchedule{
+		Directory:  app.Config.Backups.Directory,
+		Interval:   app.Config.Backups.Interval,
+		KeepLast:   app.Config.Backups.KeepLast,
+		KeepDaily:  app.Config.Backups.KeepDaily,
+		KeepWeekly: app.Config.Backups.KeepWeekly,
+	}
+}
+
+func (app *App) UploadArea() services.UploadArea {
+	directory := app.Config.Media.UploadsDirectory
+	if directory == "" {
+		directory = "uploads"
+	}
+	expiry := app.Config.Media.UploadExpiry
+	if expiry <= 0 {
+		expiry = 24
+	}
+	return services.UploadArea{
+		Directory: directory,
+		Expiry:    expiry,
+	}
+}
@@ -1,11 +1,141 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"structured-notes/app"
+	"structured-notes/models"
 	"structured-notes/permissions"
+	"structured-notes/services"
+	"structured-notes/types"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Controller struct {
 	app        *app.App
 	authorizer permissions.Authorizer
 }
+
+func (ctr *Controller) versionRetention() services.VersionRetention {
+	return services.VersionRetention{
+		MaxCount: ctr.app.Config.Versions.MaxCount,
+		MaxAge:   ctr.app.Config.Versions.MaxAge,
+	}
+}
+
+func (ctr *Controller) mediaLimits() services.MediaLimits {
+	return services.MediaLimits{
+		MaxSize:        ctr.app.Config.Media.MaxSize,
+		MaxSizes:       ctr.app.Config.Media.MaxSizes,
+		MaxUploadsSize: ctr.app.Config.Media.MaxUploadsSize,
+		SupportedTypes: ctr.app.Config.Media.SupportedTypes,
+	}
+}
+
+// nodeIdParam returns the node id of routes shaped /nodes/:id/...
+// gin requires wildcards sharing a segment to share a name, so GET routes carry it in :userId
+func nodeIdParam(c *gin.Context) string {
+	if id := c.Param("id"); id != "" {
+		return id
+	}
+	return c.Param("userId")
+}
+
+// nodeETag identifies a revision of a node, any update moves its updated_timestamp forward
+func nodeETag(node *models.Node) string {
+	return fmt.Sprintf(`"%d-%d"`, node.Id, node.UpdatedTimestamp)
+}
+
+// nodeETagTimestamps returns the revisions of nodeId listed in an If-Match or If-None-Match header.
+// It returns nil when the header is missing or "*", which any revision matches.
+// Weak ETags (W/) are skipped unless weak is set: If-Match compares strongly, If-None-Match weakly (RFC 9110).
+func nodeETagTimestamps(header string, nodeId types.Snowflake, weak bool) []int64 {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil
+	}
+
+	timestamps := make([]int64, 0)
+	for _, etag := range strings.Split(header, ",") {
+		etag, isWeak := strings.CutPrefix(strings.TrimSpace(etag), "W/")
+		if isWeak && !weak {
+			continue
+		}
+		etag = strings.Trim(etag, `"`)
+		id, timestamp, found := strings.Cut(etag, "-")
+		if !found || id != strconv.FormatUint(uint64(nodeId), 10) {
+			continue
+		}
+		if value, err := strconv.ParseInt(timestamp, 10, 64); err == nil {
+			timestamps = append(timestamps, value)
+		}
+	}
+	return timestamps
+}
+
+// selectNodeFields keeps the comma separated JSON fields of nodes, plus their id, for ?fields= parameters.
+// nodes are returned as is when fields is empty.
+func selectNodeFields(nodes []*models.Node, fields string) (any, error) {
+	if fields == "" {
+		return nodes, nil
+	}
+
+	selected := map[string]bool{"id": true}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if !nodeFields[field] {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+		selected[field] = true
+	}
+
+	result := make([]map[string]json.RawMessage, 0, len(nodes))
+	for _, node := range nodes {
+		encoded, err := json.Marshal(node)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &all); err != nil {
+			return nil, err
+		}
+
+		kept := make(map[string]json.RawMessage, len(selected))
+		for field := range selected {
+			if value, ok := all[field]; ok {
+				kept[field] = value
+			}
+		}
+		result = append(result, kept)
+	}
+	return result, nil
+}
+
+// nodeFields lists the JSON fields of models.Node
+var nodeFields = func() map[string]bool {
+	fields := map[string]bool{"child_count": true} // omitted when empty
+	encoded, _ := json.Marshal(models.Node{})
+	var all map[string]json.RawMessage
+	_ = json.Unmarshal(encoded, &all)
+	for field := range all {
+		fields[field] = true
+	}
+	return fields
+}()
+
+// queryLimit reads the optional ?limit= parameter, 0 when missing
+func queryLimit(c *gin.Context) (int, error) {
+	value := c.Query("limit")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, errors.New("invalid limit")
+	}
+	return limit, nil
+}
@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNodeETagTimestamps(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		weak   bool
+		want   []int64
+	}{
+		{"any", "*", false, nil},
+		{"strong", `"7-100"`, false, []int64{100}},
+		{"list", `"7-100", "8-200", "7-300"`, false, []int64{100, 300}},
+		{"weak skipped by If-Match", `W/"7-100", "7-300"`, false, []int64{300}},
+		{"weak only", `W/"7-100"`, false, []int64{}},
+		{"weak kept by If-None-Match", `W/"7-100"`, true, []int64{100}},
+		{"malformed", `"7"`, false, []int64{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := nodeETagTimestamps(test.header, 7, test.weak)
+			if (got == nil) != (test.want == nil) || !slices.Equal(got, test.want) {
+				t.Errorf("nodeETagTimestamps(%q) = %v, want %v", test.header, got, test.want)
+			}
+		})
+	}
+}
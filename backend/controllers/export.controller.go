@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"structured-notes/app"
+	"structured-notes/logger"
+	"structured-notes/permissions"
+	"structured-notes/services"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportController interface {
+	ExportNode(c *gin.Context)
+}
+
+func NewExportController(app *app.App) ExportController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+// ExportNode handles GET /nodes/:id/export?format=markdown|html|json, streaming a ZIP of the subtree
+func (ctr *Controller) ExportNode(c *gin.Context) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	export, err := ctr.app.Services.Export.GetExport(nodeId, c.DefaultQuery("format", services.ExportFormatMarkdown), connectedUserId, connectedUserRole, ctr.authorizer)
+	if errors.Is(err, services.ErrUnsupportedExportFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": fmt.Sprintf("%s.zip", export.Root.Name),
+	}))
+	c.Status(http.StatusOK)
+
+	// the status is sent already, a failure can only cut the archive short
+	if err := export.WriteZip(c.Writer); err != nil {
+		logger.Error(fmt.Sprintf("Error exporting node %d: %v", nodeId, err))
+	}
+}
@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"structured-notes/app"
+	"structured-notes/permissions"
+	"structured-notes/services"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GraphController interface {
+	GetGraph(c *gin.Context) (int, any)
+}
+
+func NewGraphController(app *app.App) GraphController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+// GetGraph handles GET /nodes/:id/graph?depth=
+func (ctr *Controller) GetGraph(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", strconv.Itoa(services.DefaultGraphDepth)))
+	if err != nil || depth < 0 {
+		return http.StatusBadRequest, errors.New("invalid depth")
+	}
+
+	graph, err := ctr.app.Services.Graph.GetGraph(nodeId, depth, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, graph
+}
@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"structured-notes/app"
+	"structured-notes/permissions"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ImportController interface {
+	Import(c *gin.Context) (int, any)
+}
+
+func NewImportController(app *app.App) ImportController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+// Import handles POST /import, a multipart form with a .md or .zip "file" and the "parent_id" to import under
+func (ctr *Controller) Import(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	parentId, err := utils.GetTargetId(c, c.PostForm("parent_id"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid parent_id")
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return http.StatusBadRequest, errors.New("failed to get file")
+	}
+	defer file.Close()
+
+	limits := ctr.mediaLimits()
+	usedSize, err := ctr.app.Services.Node.GetUserUploadsSize(connectedUserId)
+	if err != nil {
+		return http.StatusInternalServerError, errors.New("failed to get uploads size")
+	}
+	if header.Size > int64(limits.MaxUploadsSize)-usedSize {
+		return http.StatusBadRequest, errors.New("total size of uploads exceeds the limit")
+	}
+
+	report, err := ctr.app.Services.Import.Import(header.Filename, file, header.Size, parentId, connectedUserId, connectedUserRole, ctr.authorizer, limits)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, report
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"net/http"
+	"structured-notes/app"
+	"structured-notes/permissions"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LinkController interface {
+	GetBacklinks(c *gin.Context) (int, any)
+	GetOutlinks(c *gin.Context) (int, any)
+}
+
+func NewLinkController(app *app.App) LinkController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+func (ctr *Controller) GetBacklinks(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	links, err := ctr.app.Services.Link.GetBacklinks(nodeId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, links
+}
+
+func (ctr *Controller) GetOutlinks(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	links, err := ctr.app.Services.Link.GetOutlinks(nodeId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, links
+}
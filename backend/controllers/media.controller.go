@@ -4,22 +4,35 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 	"structured-notes/app"
 	"structured-notes/logger"
+	"structured-notes/models"
 	"structured-notes/permissions"
+	"structured-notes/services"
+	"structured-notes/storage"
 	"structured-notes/utils"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type MediaController interface {
-	GetBackup(c *gin.Context) (int, any)
+	GetBackup(c *gin.Context)
+	RestoreBackup(c *gin.Context) (int, any)
+	GetStoredBackups(c *gin.Context) (int, any)
+	DownloadStoredBackup(c *gin.Context)
 	UploadFile(c *gin.Context) (int, any)
 	UploadAvatar(c *gin.Context) (int, any)
+	CreateUpload(c *gin.Context) (int, any)
+	GetUpload(c *gin.Context) (int, any)
+	WriteUploadChunk(c *gin.Context) (int, any)
+	CompleteUpload(c *gin.Context) (int, any)
+	AbortUpload(c *gin.Context) (int, any)
+	GetAvatar(c *gin.Context)
 	DeleteUpload(c *gin.Context) (int, any)
 	GetMediaFile(c *gin.Context)
 }
@@ -31,17 +44,105 @@ func NewMediaController(app *app.App) MediaController {
 	}
 }
 
-func (ctr *Controller) GetBackup(c *gin.Context) (int, any) {
+// GetBackup streams a ZIP archive of the nodes, granted permissions and media files of the connected user
+func (ctr *Controller) GetBackup(c *gin.Context) {
 	userId, err := utils.GetUserIdCtx(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	backup, err := ctr.app.Services.Backup.GetBackup(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="backup-%d-%s.zip"`,
+		userId, time.UnixMilli(backup.Manifest.CreatedTimestamp).UTC().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	// the status is sent already, a failure can only cut the archive short
+	if err := backup.WriteZip(c.Writer); err != nil {
+		logger.Error(fmt.Sprintf("Error writing backup of user %d: %v", userId, err))
+	}
+}
+
+// RestoreBackup imports a backup archive, sent as "file", into the account of the connected user
+// or, for administrators, of the optional "user_id"
+func (ctr *Controller) RestoreBackup(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	userId := connectedUserId
+	if value := c.PostForm("user_id"); value != "" {
+		userId, err = utils.GetTargetId(c, value)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("invalid user_id")
+		}
+	}
+
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		return http.StatusBadRequest, errors.New("failed to get file")
+	}
+	defer file.Close()
+
+	report, err := ctr.app.Services.Backup.Restore(file, header.Size, userId, connectedUserId, connectedUserRole, ctr.app.Config.Media.MaxUploadsSize)
+	if errors.Is(err, services.ErrInvalidBackup) {
 		return http.StatusBadRequest, err
 	}
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, report
+}
 
-	link, err := ctr.app.Services.Media.CreateBackup(userId)
+// GetStoredBackups lists the scheduled backups of the connected user or, for administrators, of ?user_id=
+func (ctr *Controller) GetStoredBackups(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return http.StatusUnauthorized, err
+	}
+	userId := connectedUserId
+	if value := c.Query("user_id"); value != "" {
+		userId, err = utils.GetTargetId(c, value)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("invalid user_id")
+		}
+	}
+
+	backups, err := ctr.app.Services.Backup.GetStoredBackups(userId, ctr.app.BackupSchedule(), connectedUserId, connectedUserRole)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, backups
+}
+
+// DownloadStoredBackup sends a scheduled backup, see GetStoredBackups
+func (ctr *Controller) DownloadStoredBackup(c *gin.Context) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userId := connectedUserId
+	if value := c.Query("user_id"); value != "" {
+		userId, err = utils.GetTargetId(c, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+	}
+
+	fullPath, err := ctr.app.Services.Backup.GetStoredBackupPath(userId, c.Param("name"), ctr.app.BackupSchedule(), connectedUserId, connectedUserRole)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
 	}
-	return http.StatusCreated, gin.H{"link": link}
+	c.FileAttachment(fullPath, c.Param("name"))
 }
 
 func (ctr *Controller) UploadFile(c *gin.Context) (int, any) {
@@ -61,17 +162,8 @@ func (ctr *Controller) UploadFile(c *gin.Context) (int, any) {
 		return http.StatusBadRequest, err
 	}
 
-	mimeType := header.Header.Get("Content-Type")
-	node, err := ctr.app.Services.Media.UploadFile(
-		header.Filename,
-		header.Size,
-		fileContent,
-		mimeType,
-		userId,
-		ctr.app.Config.Media.MaxSize,
-		ctr.app.Config.Media.MaxUploadsSize,
-		ctr.app.Config.Media.SupportedTypes,
-	)
+	// the type is detected from the content, the Content-Type of the part is not trusted
+	node, err := ctr.app.Services.Media.UploadFile(header.Filename, fileContent, userId, ctr.mediaLimits())
 	if err != nil {
 		return http.StatusBadRequest, err
 	}
@@ -96,12 +188,9 @@ func (ctr *Controller) UploadAvatar(c *gin.Context) (int, any) {
 		return http.StatusBadRequest, err
 	}
 
-	mimeType := header.Header.Get("Content-Type")
-	err = ctr.app.Services.Media.UploadAvatar(
+	user, err := ctr.app.Services.Media.UploadAvatar(
 		header.Filename,
-		header.Size,
 		fileContent,
-		mimeType,
 		userId,
 		ctr.app.Config.Media.MaxSize,
 		ctr.app.Config.Media.SupportedTypesImages,
@@ -110,7 +199,168 @@ func (ctr *Controller) UploadAvatar(c *gin.Context) (int, any) {
 		return http.StatusBadRequest, err
 	}
 
-	return http.StatusOK, "Avatar uploaded successfully"
+	return http.StatusOK, user
+}
+
+// CreateUpload opens a resumable upload, for files too large to be sent in one request.
+// The file is then sent in chunks with WriteUploadChunk and stored as a media node by CompleteUpload.
+func (ctr *Controller) CreateUpload(c *gin.Context) (int, any) {
+	userId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var request models.UploadRequest
+	if err := c.ShouldBind(&request); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	upload, err := ctr.app.Services.Upload.CreateUpload(&request, userId, ctr.app.UploadArea(), ctr.mediaLimits())
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	return http.StatusCreated, upload
+}
+
+// GetUpload returns an upload and its offset, where a client resumes after a dropped connection
+func (ctr *Controller) GetUpload(c *gin.Context) (int, any) {
+	userId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	uploadId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid id format")
+	}
+
+	upload, err := ctr.app.Services.Upload.GetUpload(uploadId, userId, ctr.app.UploadArea())
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	return http.StatusOK, upload
+}
+
+// WriteUploadChunk appends the raw request body to an upload, at the offset given in the Upload-Offset header
+func (ctr *Controller) WriteUploadChunk(c *gin.Context) (int, any) {
+	userId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	uploadId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid id format")
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return http.StatusBadRequest, errors.New("invalid Upload-Offset header")
+	}
+
+	upload, err := ctr.app.Services.Upload.WriteChunk(uploadId, userId, offset, c.Request.Body, ctr.app.UploadArea())
+	if err != nil {
+		var mismatch *services.UploadOffsetError
+		if errors.As(err, &mismatch) {
+			c.Header("Upload-Offset", strconv.FormatInt(mismatch.Current.Offset, 10))
+			return http.StatusConflict, &utils.ErrorWithResult{Err: err, Result: mismatch.Current}
+		}
+		if errors.Is(err, services.ErrUploadNotFound) {
+			return http.StatusNotFound, err
+		}
+		if errors.Is(err, services.ErrChunkTooLarge) {
+			return http.StatusRequestEntityTooLarge, err
+		}
+		return http.StatusBadRequest, err
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	return http.StatusOK, upload
+}
+
+// CompleteUpload stores a fully sent upload as a media node, once its optional "sha256" matches the received bytes.
+// The checksum may be given when the upload is created instead.
+func (ctr *Controller) CompleteUpload(c *gin.Context) (int, any) {
+	userId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	uploadId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid id format")
+	}
+
+	var request models.UploadComplete
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBind(&request); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	node, err := ctr.app.Services.Upload.CompleteUpload(uploadId, userId, request.SHA256, ctr.app.UploadArea(), ctr.mediaLimits())
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return http.StatusNotFound, err
+	}
+	if errors.Is(err, services.ErrUploadIncomplete) {
+		return http.StatusConflict, err
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, node
+}
+
+func (ctr *Controller) AbortUpload(c *gin.Context) (int, any) {
+	userId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	uploadId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, errors.New("invalid id format")
+	}
+
+	err = ctr.app.Services.Upload.AbortUpload(uploadId, userId, ctr.app.UploadArea())
+	if errors.Is(err, services.ErrUploadNotFound) {
+		return http.StatusNotFound, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, "Upload aborted successfully"
+}
+
+// GetAvatar serves the avatar of a user at the size closest to ?size=, no authentication needed
+func (ctr *Controller) GetAvatar(c *gin.Context) {
+	userTargetId, err := utils.GetTargetId(c, c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	size := 0
+	if value := c.Query("size"); value != "" {
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid size"})
+			return
+		}
+	}
+
+	file, err := ctr.app.Services.Media.GetAvatar(userTargetId, size)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "avatar not found"})
+		return
+	}
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error opening avatar: %v", err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		return
+	}
+	defer file.Content.Close()
+
+	serveMediaFile(c, file, "image/jpeg")
 }
 
 func (ctr *Controller) DeleteUpload(c *gin.Context) (int, any) {
@@ -130,63 +380,81 @@ func (ctr *Controller) DeleteUpload(c *gin.Context) (int, any) {
 	return http.StatusOK, "Media deleted successfully"
 }
 
+// GetMediaFile serves the file of a media node, or one of its image variants with ?w=.
+// Responses carry a strong ETag and answer conditional and Range requests, see serveMediaFile.
 func (ctr *Controller) GetMediaFile(c *gin.Context) {
-	logger.Info("mediaUploads path hit")
-
 	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
 	if err != nil {
-		logger.Info("GetMediaFile: connectedUserId: " + strconv.FormatUint(uint64(connectedUserId), 10))
-		logger.Info("GetMediaFile: connectedUserRole: " + strconv.FormatInt(int64(connectedUserRole), 10))
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
 	userTargetId, err := utils.GetTargetId(c, c.Param("userId"))
 	if err != nil {
-		logger.Info("GetMediaFile: userTargetId: " + strconv.FormatUint(uint64(userTargetId), 10))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
 		return
 	}
 
-	nodeTargetId, ext, err := utils.GetMediaFilenameParts(c, c.Param("nameAndExt"))
+	nodeTargetId, _, err := utils.GetMediaFilenameParts(c, c.Param("nameAndExt"))
 	if err != nil {
-		logger.Info("GetMediaFile: nodeTargetId: " + strconv.FormatUint(uint64(nodeTargetId), 10))
-		logger.Info("GetMediaFile: ext: " + ext)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid file name"})
 		return
 	}
 
-	// checks if the user has permissions for the media
-	err = ctr.app.Services.Media.GetMediaFile(nodeTargetId, userTargetId, connectedUserId, connectedUserRole, ctr.authorizer)
-	if err != nil {
-		logger.Info("GetMediaFile")
-		return
+	// ?w= asks for the narrowest variant at least that wide
+	width := 0
+	if value := c.Query("w"); value != "" {
+		width, err = strconv.Atoi(value)
+		if err != nil || width <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid width"})
+			return
+		}
 	}
 
-	userIdStr := fmt.Sprintf("%d", userTargetId)
-	nodeIdStr := fmt.Sprintf("%d", nodeTargetId)
-	nodeIdAndExtStr := nodeIdStr + ext
-
-	fullMediaFilePath := filepath.Join("media", userIdStr, nodeIdAndExtStr)
-	// It is assumed that media folder is relative to working
-	// directory that is currentlyalso project directory
-	// Could be checked with the following snippet:
-	////
-	// wd, _ := os.Getwd()
-	// logger.Info("Working directory: " + wd)
-	////
-
-	// Check if file exists
-	if _, err := os.Stat(fullMediaFilePath); os.IsNotExist(err) {
-		logger.Warn("File not found: " + fullMediaFilePath)
+	// checks if the user has permissions for the media
+	file, err := ctr.app.Services.Media.GetMediaFile(nodeTargetId, userTargetId, width, connectedUserId, connectedUserRole, ctr.authorizer)
+	if errors.Is(err, storage.ErrNotFound) {
+		logger.Warn(fmt.Sprintf("File not found: %d/%s", userTargetId, c.Param("nameAndExt")))
 		c.JSON(http.StatusNotFound, gin.H{"error": "file not found"})
 		return
-	} else if err != nil {
-		logger.Error(fmt.Sprintf("Error checking file: %v", err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
+	defer file.Content.Close()
 
-	c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+	// variants may be stored in another format than the original
+	contentType := mime.TypeByExtension(path.Ext(file.Info.Key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	serveMediaFile(c, file, contentType)
+}
 
-	c.File(fullMediaFilePath)
+// Files never change under a key, new uploads get new keys, but the access to a private media may be revoked:
+// private files are checked again with their ETag on every use, public ones are kept for an hour.
+const (
+	publicMediaCacheControl  = "public, max-age=3600"
+	privateMediaCacheControl = "private, no-cache"
+)
 
-	logger.Info("File attached")
+// serveMediaFile sends a media file with its caching headers.
+// http.ServeContent answers If-None-Match and If-Modified-Since with 304 and serves Range requests,
+// which audio and video players rely on to seek.
+func serveMediaFile(c *gin.Context, file *services.MediaFile, contentType string) {
+	c.Header("Cross-Origin-Resource-Policy", "cross-origin")
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", file.ETag)
+	if file.Public {
+		c.Header("Cache-Control", publicMediaCacheControl)
+	} else {
+		c.Header("Cache-Control", privateMediaCacheControl)
+	}
+
+	if seeker, ok := file.Content.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, "", file.Info.LastModified, seeker)
+		return
+	}
+	c.DataFromReader(http.StatusOK, file.Info.Size, contentType, file.Content, nil)
 }
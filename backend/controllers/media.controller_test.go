@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"structured-notes/services"
+	"structured-notes/storage"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeMediaFileCacheControl(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		public bool
+		want   string
+	}{
+		{"public", true, publicMediaCacheControl},
+		{"private", false, privateMediaCacheControl},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodGet, "/media", nil)
+
+			serveMediaFile(c, &services.MediaFile{
+				Content: io.NopCloser(bytes.NewReader([]byte("content"))),
+				Info:    &storage.ObjectInfo{Key: "1/2.png", Size: 7, LastModified: time.Now()},
+				ETag:    `"etag"`,
+				Public:  test.public,
+			}, "image/png")
+
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+			}
+			if got := recorder.Header().Get("Cache-Control"); got != test.want {
+				t.Errorf("Cache-Control = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,23 +1,37 @@
 package controllers
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"slices"
 	"structured-notes/app"
 	"structured-notes/models"
 	"structured-notes/permissions"
+	"structured-notes/services"
+	"structured-notes/types"
 	"structured-notes/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 )
 
 type NodeController interface {
 	GetPublicNode(c *gin.Context) (int, any)
 	GetSharedNodes(c *gin.Context) (int, any)
 	GetNodes(c *gin.Context) (int, any)
+	GetChildren(c *gin.Context) (int, any)
 	GetNode(c *gin.Context) (int, any)
 	CreateNode(c *gin.Context) (int, any)
 	UpdateNode(c *gin.Context) (int, any)
+	PatchNode(c *gin.Context) (int, any)
+	MoveNode(c *gin.Context) (int, any)
+	DuplicateNode(c *gin.Context) (int, any)
+	InstantiateTemplate(c *gin.Context) (int, any)
 	DeleteNode(c *gin.Context) (int, any)
+	BatchNodes(c *gin.Context) (int, any)
+	GetTrash(c *gin.Context) (int, any)
+	RestoreNode(c *gin.Context) (int, any)
 }
 
 func NewNodeController(app *app.App) NodeController {
@@ -58,6 +72,7 @@ func (ctr *Controller) GetSharedNodes(c *gin.Context) (int, any) {
 	return http.StatusOK, nodes
 }
 
+// GetNodes lists the nodes of a user, paginated when ?limit= or ?cursor= is set
 func (ctr *Controller) GetNodes(c *gin.Context) (int, any) {
 	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
 	if err != nil {
@@ -68,11 +83,72 @@ func (ctr *Controller) GetNodes(c *gin.Context) (int, any) {
 		return http.StatusBadRequest, err
 	}
 
-	nodes, err := ctr.app.Services.Node.GetAllNodes(targetUserId, connectedUserId, connectedUserRole)
+	limit, err := queryLimit(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	cursor := c.Query("cursor")
+
+	if limit == 0 && cursor == "" {
+		nodes, err := ctr.app.Services.Node.GetAllNodes(targetUserId, c.Query("tag"), connectedUserId, connectedUserRole)
+		if err != nil {
+			return http.StatusUnauthorized, err
+		}
+		items, err := selectNodeFields(nodes, c.Query("fields"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		return http.StatusOK, items
+	}
+
+	page, err := ctr.app.Services.Node.GetNodesPage(targetUserId, c.Query("tag"), cursor, limit, connectedUserId, connectedUserRole)
 	if err != nil {
 		return http.StatusUnauthorized, err
 	}
-	return http.StatusOK, nodes
+	return ctr.nodePage(c, page)
+}
+
+// GetChildren handles GET /nodes/:userId/children/:parentId, parentId being "root" for the top level
+func (ctr *Controller) GetChildren(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	targetUserId, err := utils.GetTargetId(c, c.Param("userId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var parentId *types.Snowflake
+	if c.Param("parentId") != "root" {
+		id, err := utils.GetTargetId(c, c.Param("parentId"))
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		parentId = &id
+	}
+
+	limit, err := queryLimit(c)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	page, err := ctr.app.Services.Node.GetChildren(targetUserId, parentId, c.Query("cursor"), limit, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return ctr.nodePage(c, page)
+}
+
+func (ctr *Controller) nodePage(c *gin.Context, page *models.NodePage) (int, any) {
+	items, err := selectNodeFields(page.Nodes, c.Query("fields"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, gin.H{
+		"items":       items,
+		"next_cursor": page.NextCursor,
+	}
 }
 
 func (ctr *Controller) GetNode(c *gin.Context) (int, any) {
@@ -89,6 +165,16 @@ func (ctr *Controller) GetNode(c *gin.Context) (int, any) {
 	if err != nil {
 		return http.StatusUnauthorized, err
 	}
+
+	if node, ok := result["node"].(*models.Node); ok {
+		c.Header("ETag", nodeETag(node))
+		if header := c.GetHeader("If-None-Match"); header != "" {
+			timestamps := nodeETagTimestamps(header, nodeId, true)
+			if timestamps == nil || slices.Contains(timestamps, node.UpdatedTimestamp) {
+				return http.StatusNotModified, nil
+			}
+		}
+	}
 	return http.StatusOK, result
 }
 
@@ -103,6 +189,9 @@ func (ctr *Controller) CreateNode(c *gin.Context) (int, any) {
 	}
 
 	createdNode, err := ctr.app.Services.Node.CreateNode(&node, userId)
+	if errors.Is(err, services.ErrMediaRole) {
+		return http.StatusBadRequest, err
+	}
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -124,13 +213,142 @@ func (ctr *Controller) UpdateNode(c *gin.Context) (int, any) {
 		return http.StatusBadRequest, err
 	}
 
-	updatedNode, err := ctr.app.Services.Node.UpdateNode(nodeId, &node, connectedUserId, connectedUserRole, ctr.authorizer)
+	// If-Match makes the update conditional, see GetNode for the ETag
+	var ifMatch []int64
+	if header := c.GetHeader("If-Match"); header != "" {
+		ifMatch = nodeETagTimestamps(header, nodeId, false)
+	}
+
+	updatedNode, err := ctr.app.Services.Node.UpdateNode(nodeId, &node, ifMatch, connectedUserId, connectedUserRole, ctr.authorizer, ctr.versionRetention())
 	if err != nil {
+		var conflict *services.NodeConflictError
+		if errors.As(err, &conflict) {
+			c.Header("ETag", nodeETag(conflict.Current))
+			return http.StatusConflict, &utils.ErrorWithResult{Err: err, Result: conflict.Current}
+		}
+		if errors.Is(err, services.ErrMediaRole) {
+			return http.StatusBadRequest, err
+		}
 		return http.StatusUnauthorized, err
 	}
+	c.Header("ETag", nodeETag(updatedNode))
 	return http.StatusOK, updatedNode
 }
 
+// PatchNode applies a JSON merge patch (RFC 7396) to a node, If-Match is honoured as in UpdateNode
+func (ctr *Controller) PatchNode(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var patch map[string]interface{}
+	if err := c.ShouldBindJSON(&patch); err != nil || patch == nil {
+		return http.StatusBadRequest, errors.New("the body must be a JSON merge patch object")
+	}
+
+	var ifMatch []int64
+	if header := c.GetHeader("If-Match"); header != "" {
+		ifMatch = nodeETagTimestamps(header, nodeId, false)
+	}
+
+	patchedNode, err := ctr.app.Services.Node.PatchNode(nodeId, patch, ifMatch, connectedUserId, connectedUserRole, ctr.authorizer, ctr.versionRetention())
+	if err != nil {
+		var conflict *services.NodeConflictError
+		var validationErrors validator.ValidationErrors
+		switch {
+		case errors.As(err, &conflict):
+			c.Header("ETag", nodeETag(conflict.Current))
+			return http.StatusConflict, &utils.ErrorWithResult{Err: err, Result: conflict.Current}
+		case errors.As(err, &validationErrors):
+			return http.StatusBadRequest, validationErrors
+		case errors.Is(err, services.ErrInvalidPatch), errors.Is(err, services.ErrMediaRole):
+			return http.StatusBadRequest, err
+		}
+		return http.StatusUnauthorized, err
+	}
+	c.Header("ETag", nodeETag(patchedNode))
+	return http.StatusOK, patchedNode
+}
+
+func (ctr *Controller) MoveNode(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var data struct {
+		ParentId *types.Snowflake `json:"parent_id"` // null: root
+		Order    *int             `json:"order"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	movedNode, err := ctr.app.Services.Node.MoveNode(nodeId, data.ParentId, data.Order, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, movedNode
+}
+
+func (ctr *Controller) DuplicateNode(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	// the body is optional, without parent_id the copy is placed next to the original
+	var data struct {
+		ParentId *types.Snowflake `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil && !errors.Is(err, io.EOF) {
+		return http.StatusBadRequest, err
+	}
+
+	duplicatedNode, err := ctr.app.Services.Node.DuplicateNode(nodeId, data.ParentId, connectedUserId, connectedUserRole, ctr.authorizer, ctr.app.Config.Media.MaxUploadsSize)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, duplicatedNode
+}
+
+func (ctr *Controller) InstantiateTemplate(c *gin.Context) (int, any) {
+	templateId, err := utils.GetTargetId(c, c.Param("templateId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var data struct {
+		ParentId types.Snowflake `json:"parent_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&data); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	node, err := ctr.app.Services.Node.InstantiateTemplate(templateId, data.ParentId, connectedUserId, connectedUserRole, ctr.authorizer, ctr.app.Config.Media.MaxUploadsSize)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, node
+}
+
 func (ctr *Controller) DeleteNode(c *gin.Context) (int, any) {
 	nodeId, err := utils.GetTargetId(c, c.Param("id"))
 	if err != nil {
@@ -147,3 +365,59 @@ func (ctr *Controller) DeleteNode(c *gin.Context) (int, any) {
 	}
 	return http.StatusOK, "OK"
 }
+
+// BatchNodes runs create, update, patch, move and delete operations in one transaction, see BatchService
+func (ctr *Controller) BatchNodes(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var request models.BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	results, err := ctr.app.Services.Batch.RunBatch(&request, connectedUserId, connectedUserRole, ctr.versionRetention())
+	if errors.Is(err, services.ErrBatchFailed) {
+		return http.StatusBadRequest, &utils.ErrorWithResult{Err: err, Result: results}
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, results
+}
+
+func (ctr *Controller) GetTrash(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	targetUserId, err := utils.GetTargetId(c, c.Param("userId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	nodes, err := ctr.app.Services.Node.GetTrash(targetUserId, connectedUserId, connectedUserRole)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, nodes
+}
+
+func (ctr *Controller) RestoreNode(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	restoredNode, err := ctr.app.Services.Node.RestoreNode(nodeId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, restoredNode
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"structured-notes/app"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchController interface {
+	Search(c *gin.Context) (int, any)
+}
+
+func NewSearchController(app *app.App) SearchController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+// Search handles GET /search?q=&role=&tag=&owner=&updated_after=&updated_before=&limit=&offset=
+func (ctr *Controller) Search(c *gin.Context) (int, any) {
+	connectedUserId, err := utils.GetUserIdCtx(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	text := c.Query("q")
+	if text == "" {
+		return http.StatusBadRequest, errors.New("q is required")
+	}
+
+	var query models.SearchQuery
+	if role := c.Query("role"); role != "" {
+		value, err := strconv.Atoi(role)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("invalid role")
+		}
+		query.Role = &value
+	}
+	if tag := models.NormalizeTag(c.Query("tag")); tag != "" {
+		query.Tag = &tag
+	}
+	if owner := c.Query("owner"); owner != "" {
+		ownerId, err := utils.GetTargetId(c, owner)
+		if err != nil {
+			return http.StatusBadRequest, errors.New("invalid owner")
+		}
+		query.OwnerId = &ownerId
+	}
+	if query.UpdatedAfter, err = queryInt64(c, "updated_after"); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if query.UpdatedBefore, err = queryInt64(c, "updated_before"); err != nil {
+		return http.StatusBadRequest, err
+	}
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+	query.Offset, _ = strconv.Atoi(c.Query("offset"))
+
+	hits, err := ctr.app.Services.Search.Search(connectedUserId, text, query)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return http.StatusOK, hits
+}
+
+func queryInt64(c *gin.Context, key string) (*int64, error) {
+	raw := c.Query(key)
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid " + key)
+	}
+	return &value, nil
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"structured-notes/app"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagController interface {
+	GetTags(c *gin.Context) (int, any)
+	RenameTag(c *gin.Context) (int, any)
+}
+
+func NewTagController(app *app.App) TagController {
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+// GetTags lists the tags of a user with the number of nodes carrying them
+func (ctr *Controller) GetTags(c *gin.Context) (int, any) {
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	targetUserId, err := utils.GetTargetId(c, c.Param("userId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	tags, err := ctr.app.Services.Tag.GetTags(targetUserId, connectedUserId, connectedUserRole)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, tags
+}
+
+// RenameTag renames a tag across all nodes, renaming to an existing tag merges them
+func (ctr *Controller) RenameTag(c *gin.Context) (int, any) {
+	tagId, err := utils.GetTargetId(c, c.Param("id"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var body struct {
+		Name string `json:"name" binding:"required,max=50"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if name := models.NormalizeTag(body.Name); name == "" || strings.Contains(name, ",") {
+		return http.StatusBadRequest, errors.New("invalid tag name")
+	}
+
+	tag, err := ctr.app.Services.Tag.RenameTag(tagId, body.Name, connectedUserId, connectedUserRole)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, tag
+}
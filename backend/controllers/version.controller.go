@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"net/http"
+	"structured-notes/app"
+	"structured-notes/permissions"
+	"structured-notes/types"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type VersionController interface {
+	GetVersions(c *gin.Context) (int, any)
+	GetVersion(c *gin.Context) (int, any)
+	DiffVersions(c *gin.Context) (int, any)
+	RestoreVersion(c *gin.Context) (int, any)
+}
+
+func NewVersionController(app *app.App) VersionController {
+	utils.InitBluemonday()
+	return &Controller{
+		app:        app,
+		authorizer: permissions.NewAuthorizer(app.Repos.Permission),
+	}
+}
+
+func (ctr *Controller) GetVersions(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	versions, err := ctr.app.Services.Version.GetVersions(nodeId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, versions
+}
+
+func (ctr *Controller) GetVersion(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	versionId, err := utils.GetTargetId(c, c.Param("versionId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	version, err := ctr.app.Services.Version.GetVersion(nodeId, versionId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, version
+}
+
+// DiffVersions compares a version with the version given in ?to=, or with the current content when omitted
+func (ctr *Controller) DiffVersions(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	versionId, err := utils.GetTargetId(c, c.Param("versionId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	var targetVersionId *types.Snowflake
+	if to := c.Query("to"); to != "" {
+		id, err := utils.GetTargetId(c, to)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		targetVersionId = &id
+	}
+
+	diff, err := ctr.app.Services.Version.DiffVersions(nodeId, versionId, targetVersionId, connectedUserId, connectedUserRole, ctr.authorizer)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, diff
+}
+
+func (ctr *Controller) RestoreVersion(c *gin.Context) (int, any) {
+	nodeId, err := utils.GetTargetId(c, nodeIdParam(c))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	versionId, err := utils.GetTargetId(c, c.Param("versionId"))
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	connectedUserId, connectedUserRole, err := utils.GetUserContext(c)
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+
+	restoredNode, err := ctr.app.Services.Version.RestoreVersion(nodeId, versionId, connectedUserId, connectedUserRole, ctr.authorizer, ctr.versionRetention())
+	if err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return http.StatusOK, restoredNode
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -12,9 +13,13 @@ import (
 )
 
 var (
-	devMode    = flag.Bool("dev", false, "Run in development mode")
-	dbSeed     = flag.Bool("db-seed", false, "Seed the database with test data (dev mode only)")
-	dbTruncate = flag.Bool("db-truncate", false, "Truncate the database tables (dev mode only)")
+	devMode     = flag.Bool("dev", false, "Run in development mode")
+	dbSeed      = flag.Bool("db-seed", false, "Seed the database with test data (dev mode only)")
+	dbTruncate  = flag.Bool("db-truncate", false, "Truncate the database tables (dev mode only)")
+	backupPath  = flag.String("backup", "", "Write an archive of all tables and media files to the given path, then exit")
+	restorePath = flag.String("restore", "", "Load an archive of -backup into the empty database and media folder, then exit")
+	reconcile   = flag.Bool("reconcile-media", false, "Remove media files no node refers to and list media nodes without a file, then exit")
+	dryRun      = flag.Bool("dry-run", false, "With -reconcile-media, only list the orphan files")
 )
 
 func main() {
@@ -57,6 +62,38 @@ func main() {
 	}
 	//-- Seed/truncate
 
+	// Instance backup/restore, the schema is migrated by SetupServer beforehand
+	if *backupPath != "" {
+		if err := application.BackupInstance(*backupPath); err != nil {
+			fmt.Println("backup failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Instance backed up to " + *backupPath)
+		os.Exit(0)
+	}
+	if *restorePath != "" {
+		if err := application.RestoreInstance(*restorePath); err != nil {
+			fmt.Println("restore failed:", err)
+			os.Exit(1)
+		}
+		fmt.Println("Instance restored from " + *restorePath)
+		os.Exit(0)
+	}
+	//-- Instance backup/restore
+
+	if *reconcile {
+		report, err := application.Services.Media.ReconcileMedia(*dryRun)
+		if err != nil {
+			fmt.Println("media reconciliation failed:", err)
+			os.Exit(1)
+		}
+		output, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(output))
+		os.Exit(0)
+	}
+
+	application.StartJobs()
+
 	logger.Info("Starting server on port: " + port)
 	defer application.DB.Close()
 
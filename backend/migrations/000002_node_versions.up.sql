@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `node_versions` (
+    `id` BIGINT UNSIGNED PRIMARY KEY,
+    `node_id` BIGINT UNSIGNED NOT NULL,
+    `user_id` BIGINT UNSIGNED NULL COMMENT 'user whose save replaced this version',
+    `name` VARCHAR(50) NOT NULL,
+    `content` LONGTEXT NULL,
+    `content_compiled` LONGTEXT NULL,
+    `created_timestamp` BIGINT NOT NULL,
+    CONSTRAINT `node_versions_nodes_fk` FOREIGN KEY (`node_id`) REFERENCES `nodes` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `node_versions_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE SET NULL
+);
+
+-- indexes
+CREATE INDEX idx_node_versions_node_created ON node_versions(node_id, created_timestamp);
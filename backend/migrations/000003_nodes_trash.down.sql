@@ -0,0 +1,2 @@
+DROP INDEX idx_nodes_deleted ON nodes;
+ALTER TABLE `nodes` DROP COLUMN `deleted_timestamp`;
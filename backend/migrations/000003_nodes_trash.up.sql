@@ -0,0 +1,5 @@
+ALTER TABLE `nodes`
+    ADD COLUMN `deleted_timestamp` BIGINT NULL COMMENT 'set while the node is in the trash';
+
+-- indexes
+CREATE INDEX idx_nodes_deleted ON nodes(deleted_timestamp);
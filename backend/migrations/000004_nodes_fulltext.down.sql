@@ -0,0 +1 @@
+DROP INDEX idx_nodes_fulltext ON nodes;
@@ -0,0 +1 @@
+CREATE FULLTEXT INDEX idx_nodes_fulltext ON nodes(name, description, tags, content);
@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS `tags` (
+    `id` BIGINT UNSIGNED PRIMARY KEY,
+    `user_id` BIGINT UNSIGNED NOT NULL,
+    `name` VARCHAR(50) NOT NULL,
+    `created_timestamp` BIGINT NOT NULL,
+    CONSTRAINT `tags_users_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `tags_user_name_unique` UNIQUE (`user_id`, `name`)
+);
+
+CREATE TABLE IF NOT EXISTS `node_tags` (
+    `node_id` BIGINT UNSIGNED NOT NULL,
+    `tag_id` BIGINT UNSIGNED NOT NULL,
+    PRIMARY KEY (`node_id`, `tag_id`),
+    CONSTRAINT `node_tags_nodes_fk` FOREIGN KEY (`node_id`) REFERENCES `nodes` (`id`) ON DELETE CASCADE,
+    CONSTRAINT `node_tags_tags_fk` FOREIGN KEY (`tag_id`) REFERENCES `tags` (`id`) ON DELETE CASCADE
+);
+
+-- indexes
+CREATE INDEX idx_node_tags_tag_id ON node_tags(tag_id);
+
+-- backfill from the comma separated nodes.tags, tags belong to the node owner
+INSERT INTO tags (id, user_id, name, created_timestamp)
+WITH RECURSIVE split AS (
+    SELECT user_id, CAST(TRIM(SUBSTRING_INDEX(tags, ',', 1)) AS CHAR(200)) AS name,
+           CAST(SUBSTRING(tags, CHAR_LENGTH(SUBSTRING_INDEX(tags, ',', 1)) + 2) AS CHAR(200)) AS rest
+    FROM nodes
+    WHERE tags IS NOT NULL AND tags != ''
+
+    UNION ALL
+
+    SELECT user_id, TRIM(SUBSTRING_INDEX(rest, ',', 1)),
+           SUBSTRING(rest, CHAR_LENGTH(SUBSTRING_INDEX(rest, ',', 1)) + 2)
+    FROM split
+    WHERE rest != ''
+)
+SELECT UUID_SHORT(), user_id, tag_name, ROUND(UNIX_TIMESTAMP(NOW(3)) * 1000)
+FROM (
+    SELECT DISTINCT user_id, LOWER(LEFT(name, 50)) AS tag_name
+    FROM split
+    WHERE name != ''
+) AS distinct_tags;
+
+INSERT IGNORE INTO node_tags (node_id, tag_id)
+WITH RECURSIVE split AS (
+    SELECT id AS node_id, user_id, CAST(TRIM(SUBSTRING_INDEX(tags, ',', 1)) AS CHAR(200)) AS name,
+           CAST(SUBSTRING(tags, CHAR_LENGTH(SUBSTRING_INDEX(tags, ',', 1)) + 2) AS CHAR(200)) AS rest
+    FROM nodes
+    WHERE tags IS NOT NULL AND tags != ''
+
+    UNION ALL
+
+    SELECT node_id, user_id, TRIM(SUBSTRING_INDEX(rest, ',', 1)),
+           SUBSTRING(rest, CHAR_LENGTH(SUBSTRING_INDEX(rest, ',', 1)) + 2)
+    FROM split
+    WHERE rest != ''
+)
+SELECT s.node_id, t.id
+FROM split s
+INNER JOIN tags t ON t.user_id = s.user_id AND t.name = LOWER(LEFT(s.name, 50))
+WHERE s.name != '';
@@ -0,0 +1,11 @@
+-- target_id has no foreign key so that links to deleted nodes can be reported as broken
+CREATE TABLE IF NOT EXISTS `node_links` (
+    `source_id` BIGINT UNSIGNED NOT NULL,
+    `target_id` BIGINT UNSIGNED NOT NULL,
+    `created_timestamp` BIGINT NOT NULL,
+    PRIMARY KEY (`source_id`, `target_id`),
+    CONSTRAINT `node_links_nodes_fk` FOREIGN KEY (`source_id`) REFERENCES `nodes` (`id`) ON DELETE CASCADE
+);
+
+-- indexes
+CREATE INDEX idx_node_links_target_id ON node_links(target_id);
@@ -0,0 +1,4 @@
+UPDATE `nodes` SET `role` = 3 WHERE `role` = 5;
+
+ALTER TABLE `nodes`
+    MODIFY `role` TINYINT NOT NULL COMMENT '1=workspace, 2=category, 3=document, 4=media';
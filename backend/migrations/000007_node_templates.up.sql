@@ -0,0 +1,2 @@
+ALTER TABLE `nodes`
+    MODIFY `role` TINYINT NOT NULL COMMENT '1=workspace, 2=category, 3=document, 4=media, 5=template';
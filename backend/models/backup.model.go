@@ -0,0 +1,41 @@
+package models
+
+import "structured-notes/types"
+
+// BackupFormatVersion is bumped whenever the layout of backup archives changes.
+// Archives of a newer format than the server's are refused on restore.
+const BackupFormatVersion = 1
+
+// BackupManifest is stored as manifest.json at the root of a backup archive,
+// next to nodes.json, permissions.json and the media/ folder
+type BackupManifest struct {
+	FormatVersion    int                `json:"format_version"`
+	SchemaVersion    uint               `json:"schema_version"` // last migration applied to the database it was taken from
+	UserId           types.Snowflake    `json:"user_id"`
+	CreatedTimestamp int64              `json:"created_timestamp"`
+	Nodes            int                `json:"nodes"`
+	Permissions      int                `json:"permissions"`
+	Media            []*BackupMediaFile `json:"media"`
+}
+
+type BackupMediaFile struct {
+	NodeId types.Snowflake `json:"node_id"`
+	Path   string          `json:"path"` // inside the archive
+	Size   int64           `json:"size"`
+	SHA256 string          `json:"sha256"`
+}
+
+type RestoreReport struct {
+	UserId      types.Snowflake   `json:"user_id"`
+	Nodes       int               `json:"nodes"`
+	Permissions int               `json:"permissions"`
+	Media       int               `json:"media"`
+	RootIds     []types.Snowflake `json:"root_ids"` // restored nodes without a parent
+}
+
+// StoredBackup is a backup archive kept by the server, see BackupService.RunScheduledBackups
+type StoredBackup struct {
+	Name             string `json:"name"`
+	Size             int64  `json:"size"`
+	CreatedTimestamp int64  `json:"created_timestamp"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "structured-notes/types"
+
+const (
+	BatchModeAtomic     = "atomic"      // all-or-nothing, the first failure rolls back the whole batch
+	BatchModeBestEffort = "best_effort" // failed operations are rolled back on their own
+
+	BatchStatusOk         = "ok"
+	BatchStatusError      = "error"
+	BatchStatusRolledBack = "rolled_back" // succeeded, then undone by a later failure
+	BatchStatusSkipped    = "skipped"     // not run after a failure
+)
+
+type BatchOperation struct {
+	Op       string                 `json:"op" binding:"required,oneof=create update patch move delete"`
+	Id       *types.Snowflake       `json:"id"`        // target of update, patch, move and delete
+	Node     *Node                  `json:"node"`      // create and update
+	Patch    map[string]interface{} `json:"patch"`     // JSON merge patch, see PATCH /nodes/:id
+	ParentId *types.Snowflake       `json:"parent_id"` // move
+	Order    *int                   `json:"order"`     // move
+}
+
+type BatchRequest struct {
+	Mode       string            `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Operations []*BatchOperation `json:"operations" binding:"required,min=1,max=100,dive,required"`
+}
+
+type BatchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Status string `json:"status"` // see BatchStatus constants
+	Node   *Node  `json:"node,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
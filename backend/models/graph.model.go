@@ -0,0 +1,32 @@
+package models
+
+import "structured-notes/types"
+
+const (
+	GraphEdgeHierarchy = "hierarchy" // parent to child
+	GraphEdgeReference = "reference" // link in the content of the source
+	GraphEdgeTag       = "tag"       // nodes sharing tags, undirected
+)
+
+type GraphNode struct {
+	Id       types.Snowflake  `json:"id"`
+	UserId   types.Snowflake  `json:"user_id"`
+	ParentId *types.Snowflake `json:"parent_id"`
+	Name     string           `json:"name"`
+	Role     int              `json:"role"`
+	Color    *int             `json:"color"`
+	Icon     *string          `json:"icon"`
+	Depth    int              `json:"depth"` // distance to the root of the graph
+}
+
+type GraphEdge struct {
+	Source types.Snowflake `json:"source"`
+	Target types.Snowflake `json:"target"`
+	Type   string          `json:"type"` // see GraphEdge constants
+	Tags   []string        `json:"tags,omitempty"`
+}
+
+type Graph struct {
+	Nodes []*GraphNode `json:"nodes"`
+	Edges []*GraphEdge `json:"edges"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "structured-notes/types"
+
+const (
+	ImportStatusOk      = "ok"
+	ImportStatusError   = "error"
+	ImportStatusSkipped = "skipped" // not a markdown file, nor an image linked from one
+)
+
+type ImportFileResult struct {
+	Path   string           `json:"path"`   // inside the archive, folders end with a slash
+	Status string           `json:"status"` // see ImportStatus constants
+	NodeId *types.Snowflake `json:"node_id,omitempty"`
+	Error  string           `json:"error,omitempty"`
+}
+
+type ImportReport struct {
+	ParentId types.Snowflake     `json:"parent_id"`
+	Imported int                 `json:"imported"`
+	Failed   int                 `json:"failed"`
+	Files    []*ImportFileResult `json:"files"`
+}
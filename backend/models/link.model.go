@@ -0,0 +1,11 @@
+package models
+
+import "structured-notes/types"
+
+type NodeLink struct {
+	SourceId         types.Snowflake `json:"source_id"`
+	TargetId         types.Snowflake `json:"target_id"`
+	CreatedTimestamp int64           `json:"created_timestamp"`
+	Node             *Node           `json:"node"`   // the other end of the link, nil when broken
+	Broken           bool            `json:"broken"` // the target was deleted, or cannot be read by the connected user
+}
@@ -0,0 +1,19 @@
+package models
+
+import "structured-notes/types"
+
+// MediaReport is the result of a reconciliation of the media storage with the media nodes
+type MediaReport struct {
+	DryRun       bool                `json:"dry_run"`
+	Files        int                 `json:"files"`         // files checked
+	Nodes        int                 `json:"nodes"`         // media nodes checked
+	OrphanFiles  []string            `json:"orphan_files"`  // files no media node or user refers to
+	RemovedFiles int                 `json:"removed_files"` // orphan files removed, none on dry runs
+	MissingFiles []*MissingMediaFile `json:"missing_files"` // media nodes whose file is gone
+}
+
+type MissingMediaFile struct {
+	NodeId types.Snowflake `json:"node_id"`
+	UserId types.Snowflake `json:"user_id"`
+	Path   string          `json:"path"`
+}
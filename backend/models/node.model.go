@@ -2,6 +2,14 @@ package models
 
 import "structured-notes/types"
 
+const (
+	NodeRoleWorkspace = 1
+	NodeRoleCategory  = 2
+	NodeRoleDocument  = 3
+	NodeRoleMedia     = 4
+	NodeRoleTemplate  = 5 // instantiated as a document, see NodeService.InstantiateTemplate
+)
+
 type Node struct {
 	Id               types.Snowflake  `json:"id" form:"id" binding:"omitempty"`
 	UserId           types.Snowflake  `json:"user_id" form:"user_id" binding:"omitempty"`
@@ -9,7 +17,7 @@ type Node struct {
 	Name             string           `json:"name" form:"name" binding:"required,max=50"`
 	Description      *string          `json:"description" form:"description" binding:"omitempty,max=250"`
 	Tags             *string          `json:"tags" form:"tags" binding:"omitempty,max=250"`
-	Role             int              `json:"role" form:"role" binding:"omitempty"`
+	Role             int              `json:"role" form:"role" binding:"omitempty"` // see NodeRole constants
 	Color            *int             `json:"color" form:"color" binding:"omitempty"`
 	Icon             *string          `json:"icon" form:"icon" binding:"omitempty"`
 	Thumbnail        *string          `json:"thumbnail" form:"thumbnail" binding:"omitempty"`
@@ -24,7 +32,9 @@ type Node struct {
 	Metadata         *types.JSONB     `json:"metadata" form:"metadata"`
 	CreatedTimestamp int64            `json:"created_timestamp" form:"created_timestamp" binding:"omitempty"`
 	UpdatedTimestamp int64            `json:"updated_timestamp" form:"updated_timestamp" binding:"omitempty"`
+	DeletedTimestamp *int64           `json:"deleted_timestamp" form:"deleted_timestamp" binding:"omitempty"` // set while in the trash
 
 	// Relations
 	Permissions []*Permission `json:"permissions" form:"permissions" binding:"omitempty"`
+	ChildCount  *int          `json:"child_count,omitempty" form:"-"` // only set by children listings
 }
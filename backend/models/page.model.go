@@ -0,0 +1,15 @@
+package models
+
+import "structured-notes/types"
+
+// NodeCursor is the position after which the next page of a listing starts, sent to clients as an opaque string
+type NodeCursor struct {
+	Role int             `json:"r,omitempty"`
+	Name string          `json:"n,omitempty"`
+	Id   types.Snowflake `json:"i"`
+}
+
+type NodePage struct {
+	Nodes      []*Node `json:"items"`
+	NextCursor *string `json:"next_cursor"` // null on the last page
+}
@@ -0,0 +1,20 @@
+package models
+
+import "structured-notes/types"
+
+type SearchQuery struct {
+	Terms         []string
+	Role          *int
+	Tag           *string
+	OwnerId       *types.Snowflake
+	UpdatedAfter  *int64
+	UpdatedBefore *int64
+	Limit         int
+	Offset        int
+}
+
+type SearchHit struct {
+	Node    *Node   `json:"node"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"` // HTML escaped, matches wrapped in <mark>
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strings"
+	"structured-notes/types"
+)
+
+type Tag struct {
+	Id               types.Snowflake `json:"id"`
+	UserId           types.Snowflake `json:"user_id"`
+	Name             string          `json:"name"`
+	Count            int             `json:"count"` // nodes carrying the tag, outside of the trash
+	CreatedTimestamp int64           `json:"created_timestamp"`
+}
+
+// MaxTagLength matches the size of tags.name
+const MaxTagLength = 50
+
+// NormalizeTag lowercases a tag and collapses its whitespace so that "Go  Lang" and "go lang" are the same tag
+func NormalizeTag(tag string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if runes := []rune(normalized); len(runes) > MaxTagLength {
+		normalized = strings.TrimSpace(string(runes[:MaxTagLength]))
+	}
+	return normalized
+}
+
+// ParseTags splits the comma separated tags of a node into normalized, unique tags, in order
+func ParseTags(tags *string) []string {
+	parsed := make([]string, 0)
+	if tags == nil {
+		return parsed
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range strings.Split(*tags, ",") {
+		tag = NormalizeTag(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		parsed = append(parsed, tag)
+	}
+	return parsed
+}
+
+// JoinTags is the reverse of ParseTags
+func JoinTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
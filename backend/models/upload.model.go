@@ -0,0 +1,27 @@
+package models
+
+import "structured-notes/types"
+
+// UploadRequest opens a resumable upload, the file is then sent in chunks, see UploadService
+type UploadRequest struct {
+	Filename string `json:"filename" binding:"required,max=255"`
+	Size     int64  `json:"size" binding:"required,gt=0"`
+	SHA256   string `json:"sha256" binding:"omitempty,len=64,hexadecimal"` // may be sent on completion instead
+}
+
+// Upload is a resumable upload in progress, its media node is created once all of its bytes are received
+type Upload struct {
+	Id               types.Snowflake `json:"id"`
+	UserId           types.Snowflake `json:"user_id"`
+	Filename         string          `json:"filename"`
+	MimeType         string          `json:"mime_type"` // from the extension, the content is checked on completion
+	Size             int64           `json:"size"`
+	Offset           int64           `json:"offset"` // bytes received, the next chunk starts there
+	SHA256           string          `json:"sha256,omitempty"`
+	CreatedTimestamp int64           `json:"created_timestamp"`
+	ExpiresTimestamp int64           `json:"expires_timestamp"` // pushed back by every chunk
+}
+
+type UploadComplete struct {
+	SHA256 string `json:"sha256" binding:"omitempty,len=64,hexadecimal"`
+}
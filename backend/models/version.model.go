@@ -0,0 +1,14 @@
+package models
+
+import "structured-notes/types"
+
+// NodeVersion is a snapshot of a node's content taken before it was overwritten
+type NodeVersion struct {
+	Id               types.Snowflake  `json:"id"`
+	NodeId           types.Snowflake  `json:"node_id"`
+	UserId           *types.Snowflake `json:"user_id"` // user whose save replaced this version
+	Name             string           `json:"name"`
+	Content          *string          `json:"content"`
+	ContentCompiled  *string          `json:"content_compiled"`
+	CreatedTimestamp int64            `json:"created_timestamp"` // when this content was saved
+}
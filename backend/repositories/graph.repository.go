@@ -0,0 +1,166 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/types"
+)
+
+type GraphRepository interface {
+	// GetGraph returns the subtree of nodeId down to maxDepth levels with the edges between its nodes.
+	// Hierarchy edges are derived from the nodes' parent_id.
+	GetGraph(nodeId types.Snowflake, maxDepth int) (*models.Graph, error)
+}
+
+type GraphRepositoryImpl struct {
+	db      *sql.DB
+	manager *RepositoryManager
+}
+
+const (
+	stmtGraphGetNodes          = "graph_get_nodes"
+	stmtGraphGetReferenceEdges = "graph_get_reference_edges"
+	stmtGraphGetTagEdges       = "graph_get_tag_edges"
+)
+
+// graphSubtreeQuery selects the subtree of a node down to a depth, trashed nodes excluded
+const graphSubtreeQuery = `
+	WITH RECURSIVE subtree AS (
+		SELECT id, 0 AS depth
+		FROM nodes
+		WHERE id = ? AND deleted_timestamp IS NULL
+
+		UNION ALL
+
+		SELECT c.id, s.depth + 1
+		FROM nodes c
+		INNER JOIN subtree s ON c.parent_id = s.id
+		WHERE c.deleted_timestamp IS NULL AND s.depth < ?
+	)`
+
+func NewGraphRepository(db *sql.DB, manager *RepositoryManager) (GraphRepository, error) {
+	repo := &GraphRepositoryImpl{
+		db:      db,
+		manager: manager,
+	}
+
+	if err := repo.prepareStatements(); err != nil {
+		return nil, fmt.Errorf("failed to prepare graph statements: %w", err)
+	}
+
+	return repo, nil
+}
+
+func (r *GraphRepositoryImpl) prepareStatements() error {
+	statements := map[string]string{
+		stmtGraphGetNodes: graphSubtreeQuery + `
+			SELECT n.id, n.user_id, n.parent_id, n.name, n.role, n.color, n.icon, s.depth
+			FROM subtree s
+			INNER JOIN nodes n ON n.id = s.id
+			ORDER BY s.depth, n.order, n.name`,
+
+		stmtGraphGetReferenceEdges: graphSubtreeQuery + `
+			SELECT l.source_id, l.target_id
+			FROM node_links l
+			INNER JOIN subtree sa ON sa.id = l.source_id
+			INNER JOIN subtree sb ON sb.id = l.target_id`,
+
+		// tags are owned per user, nodes of different owners share a tag by name
+		stmtGraphGetTagEdges: graphSubtreeQuery + `
+			SELECT a.node_id, b.node_id, GROUP_CONCAT(DISTINCT ta.name ORDER BY ta.name SEPARATOR ',')
+			FROM node_tags a
+			INNER JOIN subtree sa ON sa.id = a.node_id
+			INNER JOIN tags ta ON ta.id = a.tag_id
+			INNER JOIN tags tb ON tb.name = ta.name
+			INNER JOIN node_tags b ON b.tag_id = tb.id
+			INNER JOIN subtree sb ON sb.id = b.node_id
+			WHERE a.node_id < b.node_id
+			GROUP BY a.node_id, b.node_id`,
+	}
+
+	for key, query := range statements {
+		if _, err := r.manager.PrepareStatement(key, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *GraphRepositoryImpl) GetGraph(nodeId types.Snowflake, maxDepth int) (*models.Graph, error) {
+	graph := &models.Graph{
+		Nodes: make([]*models.GraphNode, 0),
+		Edges: make([]*models.GraphEdge, 0),
+	}
+
+	err := r.query(stmtGraphGetNodes, nodeId, maxDepth, func(rows *sql.Rows) error {
+		var node models.GraphNode
+		if err := rows.Scan(&node.Id, &node.UserId, &node.ParentId, &node.Name, &node.Role, &node.Color, &node.Icon, &node.Depth); err != nil {
+			return err
+		}
+		graph.Nodes = append(graph.Nodes, &node)
+
+		// parents come first, the root has no edge to its own parent
+		if node.Depth > 0 {
+			graph.Edges = append(graph.Edges, &models.GraphEdge{
+				Source: *node.ParentId,
+				Target: node.Id,
+				Type:   models.GraphEdgeHierarchy,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get graph nodes: %w", err)
+	}
+
+	err = r.query(stmtGraphGetReferenceEdges, nodeId, maxDepth, func(rows *sql.Rows) error {
+		edge := models.GraphEdge{Type: models.GraphEdgeReference}
+		if err := rows.Scan(&edge.Source, &edge.Target); err != nil {
+			return err
+		}
+		graph.Edges = append(graph.Edges, &edge)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get graph references: %w", err)
+	}
+
+	err = r.query(stmtGraphGetTagEdges, nodeId, maxDepth, func(rows *sql.Rows) error {
+		edge := models.GraphEdge{Type: models.GraphEdgeTag}
+		var tags string
+		if err := rows.Scan(&edge.Source, &edge.Target, &tags); err != nil {
+			return err
+		}
+		edge.Tags = strings.Split(tags, ",")
+		graph.Edges = append(graph.Edges, &edge)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get graph tags: %w", err)
+	}
+
+	return graph, nil
+}
+
+func (r *GraphRepositoryImpl) query(key string, nodeId types.Snowflake, maxDepth int, scan func(rows *sql.Rows) error) error {
+	stmt, err := r.manager.GetStatement(key)
+	if err != nil {
+		return err
+	}
+
+	rows, err := stmt.Query(nodeId, maxDepth)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,148 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"structured-notes/models"
+	"structured-notes/types"
+)
+
+type LinkRepository interface {
+	GetBySource(sourceId types.Snowflake) ([]*models.NodeLink, error)
+	GetByTarget(targetId types.Snowflake) ([]*models.NodeLink, error)
+	SetLinks(sourceId types.Snowflake, targetIds []types.Snowflake, timestamp int64) error
+}
+
+type LinkRepositoryImpl struct {
+	db      *sql.DB
+	manager *RepositoryManager
+}
+
+const (
+	stmtLinkGetBySource = "link_get_by_source"
+	stmtLinkGetByTarget = "link_get_by_target"
+	stmtLinkClearSource = "link_clear_source"
+	stmtLinkCreate      = "link_create"
+)
+
+func NewLinkRepository(db *sql.DB, manager *RepositoryManager) (LinkRepository, error) {
+	repo := &LinkRepositoryImpl{
+		db:      db,
+		manager: manager,
+	}
+
+	if err := repo.prepareStatements(); err != nil {
+		return nil, fmt.Errorf("failed to prepare link statements: %w", err)
+	}
+
+	return repo, nil
+}
+
+func (r *LinkRepositoryImpl) prepareStatements() error {
+	statements := map[string]string{
+		stmtLinkGetBySource: `
+			SELECT source_id, target_id, created_timestamp
+			FROM node_links
+			WHERE source_id = ?
+			ORDER BY created_timestamp, target_id`,
+
+		// links from trashed nodes are left out
+		stmtLinkGetByTarget: `
+			SELECT l.source_id, l.target_id, l.created_timestamp
+			FROM node_links l
+			INNER JOIN nodes n ON n.id = l.source_id
+			WHERE l.target_id = ? AND n.deleted_timestamp IS NULL
+			ORDER BY l.created_timestamp, l.source_id`,
+
+		stmtLinkClearSource: `
+			DELETE FROM node_links WHERE source_id = ?`,
+
+		stmtLinkCreate: `
+			INSERT IGNORE INTO node_links (source_id, target_id, created_timestamp)
+			VALUES (?, ?, ?)`,
+	}
+
+	for key, query := range statements {
+		if _, err := r.manager.PrepareStatement(key, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *LinkRepositoryImpl) GetBySource(sourceId types.Snowflake) ([]*models.NodeLink, error) {
+	return r.query(stmtLinkGetBySource, sourceId)
+}
+
+func (r *LinkRepositoryImpl) GetByTarget(targetId types.Snowflake) ([]*models.NodeLink, error) {
+	return r.query(stmtLinkGetByTarget, targetId)
+}
+
+func (r *LinkRepositoryImpl) query(key string, nodeId types.Snowflake) ([]*models.NodeLink, error) {
+	stmt, err := r.manager.GetStatement(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node links: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]*models.NodeLink, 0)
+	for rows.Next() {
+		var link models.NodeLink
+		if err := rows.Scan(&link.SourceId, &link.TargetId, &link.CreatedTimestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan node link: %w", err)
+		}
+		links = append(links, &link)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating node links: %w", err)
+	}
+
+	return links, nil
+}
+
+// SetLinks replaces the outgoing links of a node.
+// Links kept from the previous content keep their creation timestamp.
+func (r *LinkRepositoryImpl) SetLinks(sourceId types.Snowflake, targetIds []types.Snowflake, timestamp int64) error {
+	existing, err := r.GetBySource(sourceId)
+	if err != nil {
+		return err
+	}
+	created := make(map[types.Snowflake]int64, len(existing))
+	for _, link := range existing {
+		created[link.TargetId] = link.CreatedTimestamp
+	}
+
+	clearStmt, err := r.manager.GetStatement(stmtLinkClearSource)
+	if err != nil {
+		return err
+	}
+	createStmt, err := r.manager.GetStatement(stmtLinkCreate)
+	if err != nil {
+		return err
+	}
+
+	return r.manager.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Stmt(clearStmt).Exec(sourceId); err != nil {
+			return fmt.Errorf("failed to clear node links: %w", err)
+		}
+
+		create := tx.Stmt(createStmt)
+		for _, targetId := range targetIds {
+			createdTimestamp, ok := created[targetId]
+			if !ok {
+				createdTimestamp = timestamp
+			}
+			if _, err := create.Exec(sourceId, targetId, createdTimestamp); err != nil {
+				return fmt.Errorf("failed to create node link: %w", err)
+			}
+		}
+		return nil
+	})
+}
@@ -9,11 +9,18 @@ import (
 
 type RepositoryManager struct {
 	db          *sql.DB
+	tx          *sql.Tx            // set on the managers of WithTransaction
+	parent      *RepositoryManager // owns the prepared statements when tx is set
 	User        UserRepository
 	Node        NodeRepository
 	Session     SessionRepository
 	Permission  PermissionRepository
 	Log         LogRepository
+	Version     VersionRepository
+	Search      SearchRepository
+	Tag         TagRepository
+	Link        LinkRepository
+	Graph       GraphRepository
 	statements  map[string]*sql.Stmt
 	stmtMutex   sync.RWMutex
 	initialized bool
@@ -62,10 +69,64 @@ func (rm *RepositoryManager) initializeRepositories() error {
 		return fmt.Errorf("failed to initialize log repository: %w", err)
 	}
 
+	rm.Version, err = NewVersionRepository(rm.db, rm)
+	if err != nil {
+		return fmt.Errorf("failed to initialize version repository: %w", err)
+	}
+
+	rm.Search, err = NewMySQLSearchRepository(rm.db, rm)
+	if err != nil {
+		return fmt.Errorf("failed to initialize search repository: %w", err)
+	}
+
+	rm.Tag, err = NewTagRepository(rm.db, rm)
+	if err != nil {
+		return fmt.Errorf("failed to initialize tag repository: %w", err)
+	}
+
+	rm.Link, err = NewLinkRepository(rm.db, rm)
+	if err != nil {
+		return fmt.Errorf("failed to initialize link repository: %w", err)
+	}
+
+	rm.Graph, err = NewGraphRepository(rm.db, rm)
+	if err != nil {
+		return fmt.Errorf("failed to initialize graph repository: %w", err)
+	}
+
 	return nil
 }
 
+// Querier is implemented by both *sql.DB and *sql.Tx
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// Querier returns what dynamic queries should run on, the transaction of the manager if any
+func (rm *RepositoryManager) Querier() Querier {
+	if rm.tx != nil {
+		return rm.tx
+	}
+	return rm.db
+}
+
+// SchemaVersion returns the version of the last migration applied to the database
+func (rm *RepositoryManager) SchemaVersion() (uint, error) {
+	var version uint
+	if err := rm.Querier().QueryRow("SELECT version FROM schema_migrations LIMIT 1").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
 func (rm *RepositoryManager) PrepareStatement(key string, query string) (*sql.Stmt, error) {
+	if rm.parent != nil {
+		return rm.parent.PrepareStatement(key, query)
+	}
+
 	rm.stmtMutex.Lock()
 	defer rm.stmtMutex.Unlock()
 
@@ -86,6 +147,14 @@ func (rm *RepositoryManager) PrepareStatement(key string, query string) (*sql.St
 }
 
 func (rm *RepositoryManager) GetStatement(key string) (*sql.Stmt, error) {
+	if rm.parent != nil {
+		stmt, err := rm.parent.GetStatement(key)
+		if err != nil {
+			return nil, err
+		}
+		return rm.tx.Stmt(stmt), nil
+	}
+
 	rm.stmtMutex.RLock()
 	defer rm.stmtMutex.RUnlock()
 
@@ -96,6 +165,69 @@ func (rm *RepositoryManager) GetStatement(key string) (*sql.Stmt, error) {
 	return stmt, nil
 }
 
+// Transaction runs fn in a database transaction, committed when fn returns nil and rolled back otherwise.
+// Cached statements are bound to it with tx.Stmt. On the managers of WithTransaction, fn joins the ongoing transaction.
+func (rm *RepositoryManager) Transaction(fn func(tx *sql.Tx) error) error {
+	if rm.tx != nil {
+		return fn(rm.tx)
+	}
+
+	tx, err := rm.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// WithTransaction runs fn with repositories bound to a single database transaction,
+// committed when fn returns nil and rolled back otherwise
+func (rm *RepositoryManager) WithTransaction(fn func(repos *RepositoryManager) error) error {
+	return rm.Transaction(func(tx *sql.Tx) error {
+		txManager := &RepositoryManager{
+			db:     rm.db,
+			tx:     tx,
+			parent: rm,
+		}
+		// statements are already prepared on the parent, this only builds the repositories
+		if err := txManager.initializeRepositories(); err != nil {
+			return err
+		}
+		return fn(txManager)
+	})
+}
+
+// Savepoint runs fn inside a savepoint of the transaction of the manager, only the changes of fn are rolled back when it fails
+func (rm *RepositoryManager) Savepoint(name string, fn func() error) error {
+	if rm.tx == nil {
+		return fmt.Errorf("savepoint %s outside of a transaction", name)
+	}
+
+	if _, err := rm.tx.Exec("SAVEPOINT " + name); err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+	if err := fn(); err != nil {
+		if _, rollbackErr := rm.tx.Exec("ROLLBACK TO SAVEPOINT " + name); rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint failed: %v)", err, rollbackErr)
+		}
+		return err
+	}
+	if _, err := rm.tx.Exec("RELEASE SAVEPOINT " + name); err != nil {
+		return fmt.Errorf("failed to release savepoint: %w", err)
+	}
+	return nil
+}
+
 func (rm *RepositoryManager) Close() error {
 	rm.stmtMutex.Lock()
 	defer rm.stmtMutex.Unlock()
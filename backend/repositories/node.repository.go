@@ -10,14 +10,31 @@ import (
 
 type NodeRepository interface {
 	GetAll(userId types.Snowflake) ([]*models.Node, error)
+	GetAllByTag(userId types.Snowflake, tag string) ([]*models.Node, error)
+	GetAllPage(userId types.Snowflake, tag string, afterId types.Snowflake, limit int) ([]*models.Node, error)
+	GetChildren(parentId types.Snowflake, after models.NodeCursor, limit int) ([]*models.Node, error)
+	GetRoots(userId types.Snowflake, after models.NodeCursor, limit int) ([]*models.Node, error)
 	GetShared(userId types.Snowflake) ([]*models.Node, error)
 	GetAllForBackup(userId types.Snowflake) ([]*models.Node, error)
 	GetByID(nodeId types.Snowflake) (*models.Node, error)
+	GetSubtree(nodeId types.Snowflake) ([]*models.Node, error)
 	GetPublic(nodeId types.Snowflake) (*models.Node, error)
 	GetUserUploadsSize(userId types.Snowflake) (int64, error)
+	GetTrash(userId types.Snowflake) ([]*models.Node, error)
+	GetTrashedByID(nodeId types.Snowflake) (*models.Node, error)
+	GetTrashedBefore(timestamp int64) ([]*models.Node, error)
+	GetAllMedia() ([]*models.Node, error)
+	Lock(nodeId types.Snowflake) (bool, error)
+	LockAncestors(nodeId types.Snowflake) ([]types.Snowflake, error)
 	Create(node *models.Node) error
+	CreateMany(nodes []*models.Node) error
 	Update(node *models.Node) error
+	UpdateIfUnchanged(node *models.Node, updatedTimestamp int64) (bool, error)
+	Move(nodeId types.Snowflake, parentId *types.Snowflake, order *int, timestamp int64) error
+	Trash(nodeId types.Snowflake, timestamp int64) error
+	Restore(nodeId types.Snowflake) error
 	Delete(nodeId types.Snowflake) error
+	DeleteTrashedBefore(timestamp int64) (int64, error)
 }
 
 type NodeRepositoryImpl struct {
@@ -27,14 +44,28 @@ type NodeRepositoryImpl struct {
 
 const (
 	stmNodeGetAll              = "node_get_all"
+	stmtNodeGetAllByTag        = "node_get_all_by_tag"
+	stmtNodeGetChildren        = "node_get_children"
+	stmtNodeGetRoots           = "node_get_roots"
 	stmNodeGetShared           = "node_get_shared"
 	stmNodeGetAllForBackup     = "node_get_all_backup"
 	stmtNodeGetByID            = "node_get_by_id"
+	stmtNodeGetSubtree         = "node_get_subtree"
 	stmtNodeGetPublic          = "node_get_public"
 	stmtNodeGetUserUploadsSize = "node_get_user_uploads_size"
+	stmtNodeGetTrash           = "node_get_trash"
+	stmtNodeGetTrashedByID     = "node_get_trashed_by_id"
+	stmtNodeGetTrashedBefore   = "node_get_trashed_before"
+	stmtNodeGetAllMedia        = "node_get_all_media"
+	stmtNodeLock               = "node_lock"
 	stmtNodeCreate             = "node_create"
 	stmtNodeUpdate             = "node_update"
+	stmtNodeUpdateIfUnchanged  = "node_update_if_unchanged"
+	stmtNodeMove               = "node_move"
+	stmtNodeTrash              = "node_trash"
+	stmtNodeRestore            = "node_restore"
 	stmtNodeDelete             = "node_delete"
+	stmtNodeDeleteTrashed      = "node_delete_trashed"
 )
 
 func NewNodeRepository(db *sql.DB, manager *RepositoryManager) (NodeRepository, error) {
@@ -50,31 +81,65 @@ func NewNodeRepository(db *sql.DB, manager *RepositoryManager) (NodeRepository,
 	return repo, nil
 }
 
-func (r *NodeRepositoryImpl) prepareStatements() error {
-	statements := map[string]string{
-
-		stmNodeGetAll: `
+// userNodesQuery selects the nodes of a user and everything below them
+const userNodesQuery = `
 		WITH RECURSIVE user_nodes AS (
 		SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
 				   n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp
 		FROM nodes n
-		WHERE n.user_id = ?
+		WHERE n.user_id = ? AND n.deleted_timestamp IS NULL
 
 		UNION
 
 		SELECT c.id, c.user_id, c.parent_id, c.name, c.description, c.tags, c.role, c.color, c.icon, c.theme,
 				   c.accessibility, c.access, c.display, c.order, c.size, c.metadata, c.created_timestamp, c.updated_timestamp
 		FROM nodes c
-		JOIN user_nodes un ON un.id = c.parent_id)
+		JOIN user_nodes un ON un.id = c.parent_id
+		WHERE c.deleted_timestamp IS NULL)
+`
+
+func (r *NodeRepositoryImpl) prepareStatements() error {
+	statements := map[string]string{
+
+		stmNodeGetAll: userNodesQuery + `
 		SELECT * FROM user_nodes ORDER BY role, 'order' DESC, name;`,
 
+		stmtNodeGetAllByTag: userNodesQuery + `
+		SELECT * FROM user_nodes un
+		WHERE EXISTS (
+			SELECT 1
+			FROM node_tags nt
+			INNER JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.node_id = un.id AND t.name = ?
+		)
+		ORDER BY role, 'order' DESC, name;`,
+
+		// children listings page on (role, name, id), see models.NodeCursor
+		stmtNodeGetChildren: `
+		SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
+		       n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp,
+		       (SELECT COUNT(*) FROM nodes c WHERE c.parent_id = n.id AND c.deleted_timestamp IS NULL) AS child_count
+		FROM nodes n
+		WHERE n.parent_id = ? AND n.deleted_timestamp IS NULL AND (n.role, n.name, n.id) > (?, ?, ?)
+		ORDER BY n.role, n.name, n.id
+		LIMIT ?`,
+
+		stmtNodeGetRoots: `
+		SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
+		       n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp,
+		       (SELECT COUNT(*) FROM nodes c WHERE c.parent_id = n.id AND c.deleted_timestamp IS NULL) AS child_count
+		FROM nodes n
+		WHERE n.user_id = ? AND n.parent_id IS NULL AND n.deleted_timestamp IS NULL AND (n.role, n.name, n.id) > (?, ?, ?)
+		ORDER BY n.role, n.name, n.id
+		LIMIT ?`,
+
 		stmNodeGetShared: `
 		WITH RECURSIVE shared_nodes AS (
 		    SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
 		           n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp
 		    FROM nodes n
 		    JOIN permissions p ON p.node_id = n.id
-		    WHERE p.user_id = ?
+		    WHERE p.user_id = ? AND n.deleted_timestamp IS NULL
 
 		    UNION
 
@@ -82,35 +147,94 @@ func (r *NodeRepositoryImpl) prepareStatements() error {
 		           c.accessibility, c.access, c.display, c.order, c.size, c.metadata, c.created_timestamp, c.updated_timestamp
 		    FROM nodes c
 		    JOIN shared_nodes an ON an.id = c.parent_id
+		    WHERE c.deleted_timestamp IS NULL
 		)
 		SELECT * FROM shared_nodes;`,
 
 		stmNodeGetAllForBackup: `
 		SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
 		       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
-		       created_timestamp, updated_timestamp 
+		       created_timestamp, updated_timestamp, deleted_timestamp 
 		FROM nodes 
-		WHERE user_id = ?`,
+		WHERE user_id = ? AND deleted_timestamp IS NULL`,
 
 		stmtNodeGetByID: `
 			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
 			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
-			       created_timestamp, updated_timestamp 
+			       created_timestamp, updated_timestamp, deleted_timestamp 
 			FROM nodes 
-			WHERE id = ?`,
+			WHERE id = ? AND deleted_timestamp IS NULL`,
+
+		// parents always come before their children
+		stmtNodeGetSubtree: `
+			WITH RECURSIVE subtree AS (
+				SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme,
+				       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata,
+				       created_timestamp, updated_timestamp, deleted_timestamp, 0 AS depth
+				FROM nodes
+				WHERE id = ? AND deleted_timestamp IS NULL
+
+				UNION ALL
+
+				SELECT c.id, c.user_id, c.parent_id, c.name, c.description, c.tags, c.role, c.color, c.icon, c.thumbnail, c.theme,
+				       c.accessibility, c.access, c.display, c.` + "`order`" + `, c.content, c.content_compiled, c.size, c.metadata,
+				       c.created_timestamp, c.updated_timestamp, c.deleted_timestamp, s.depth + 1
+				FROM nodes c
+				INNER JOIN subtree s ON c.parent_id = s.id
+				WHERE c.deleted_timestamp IS NULL
+			)
+			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme,
+			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata,
+			       created_timestamp, updated_timestamp, deleted_timestamp
+			FROM subtree
+			ORDER BY depth`,
 
 		stmtNodeGetPublic: `
 			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
 			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
-			       created_timestamp, updated_timestamp 
+			       created_timestamp, updated_timestamp, deleted_timestamp 
 			FROM nodes 
-			WHERE id = ? AND accessibility = 3`,
+			WHERE id = ? AND accessibility = 3 AND deleted_timestamp IS NULL`,
 
+		// trashed media still takes up space until it is purged
 		stmtNodeGetUserUploadsSize: `
 			SELECT COALESCE(SUM(size), 0) 
 			FROM nodes 
 			WHERE user_id = ?`,
 
+		// only the roots of trashed subtrees, their descendants share the root deleted_timestamp
+		stmtNodeGetTrash: `
+			SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
+			       n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp,
+			       n.deleted_timestamp
+			FROM nodes n
+			LEFT JOIN nodes p ON p.id = n.parent_id
+			WHERE n.user_id = ? AND n.deleted_timestamp IS NOT NULL
+			  AND (p.id IS NULL OR p.deleted_timestamp IS NULL OR p.deleted_timestamp != n.deleted_timestamp)
+			ORDER BY n.deleted_timestamp DESC`,
+
+		stmtNodeGetTrashedByID: `
+			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
+			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
+			       created_timestamp, updated_timestamp, deleted_timestamp 
+			FROM nodes 
+			WHERE id = ? AND deleted_timestamp IS NOT NULL`,
+
+		stmtNodeGetTrashedBefore: `
+			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
+			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
+			       created_timestamp, updated_timestamp, deleted_timestamp 
+			FROM nodes 
+			WHERE deleted_timestamp < ?`,
+
+		// trashed media keep their file until they are purged
+		stmtNodeGetAllMedia: `
+			SELECT id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
+			       accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
+			       created_timestamp, updated_timestamp, deleted_timestamp 
+			FROM nodes 
+			WHERE role = 4`,
+
 		stmtNodeCreate: `
 			INSERT INTO nodes (id, user_id, parent_id, name, description, tags, role, color, icon, thumbnail, theme, 
 			                   accessibility, access, display, ` + "`order`" + `, content, content_compiled, size, metadata, 
@@ -124,9 +248,67 @@ func (r *NodeRepositoryImpl) prepareStatements() error {
 			    content = ?, content_compiled = ?, metadata = ?, updated_timestamp = ? 
 			WHERE id = ?`,
 
+		stmtNodeUpdateIfUnchanged: `
+			UPDATE nodes
+			SET parent_id = ?, user_id = ?, name = ?, description = ?, tags = ?, role = ?, color = ?,
+			    icon = ?, thumbnail = ?, theme = ?, accessibility = ?, access = ?, display = ?, ` + "`order`" + ` = ?,
+			    content = ?, content_compiled = ?, metadata = ?, updated_timestamp = ?
+			WHERE id = ? AND updated_timestamp = ?`,
+
+		// locks the row of a node and returns its parent
+		stmtNodeLock: `
+			SELECT parent_id
+			FROM nodes
+			WHERE id = ?
+			FOR UPDATE`,
+
+		stmtNodeMove: `
+			UPDATE nodes 
+			SET parent_id = ?, ` + "`order`" + ` = COALESCE(?, ` + "`order`" + `), updated_timestamp = ? 
+			WHERE id = ?`,
+
+		// descendants already in the trash keep their own deleted_timestamp
+		stmtNodeTrash: `
+			WITH RECURSIVE subtree AS (
+				SELECT id
+				FROM nodes
+				WHERE id = ? AND deleted_timestamp IS NULL
+
+				UNION ALL
+
+				SELECT c.id
+				FROM nodes c
+				INNER JOIN subtree s ON c.parent_id = s.id
+				WHERE c.deleted_timestamp IS NULL
+			)
+			UPDATE nodes n
+			INNER JOIN subtree s ON s.id = n.id
+			SET n.deleted_timestamp = ?`,
+
+		stmtNodeRestore: `
+			WITH RECURSIVE subtree AS (
+				SELECT id, deleted_timestamp
+				FROM nodes
+				WHERE id = ? AND deleted_timestamp IS NOT NULL
+
+				UNION ALL
+
+				SELECT c.id, c.deleted_timestamp
+				FROM nodes c
+				INNER JOIN subtree s ON c.parent_id = s.id
+				WHERE c.deleted_timestamp = s.deleted_timestamp
+			)
+			UPDATE nodes n
+			INNER JOIN subtree s ON s.id = n.id
+			SET n.deleted_timestamp = NULL`,
+
 		stmtNodeDelete: `
 			DELETE FROM nodes 
 			WHERE id = ?`,
+
+		stmtNodeDeleteTrashed: `
+			DELETE FROM nodes 
+			WHERE deleted_timestamp < ?`,
 	}
 
 	for key, query := range statements {
@@ -164,6 +346,7 @@ func (r *NodeRepositoryImpl) scanNode(scanner interface {
 		&node.Metadata,
 		&node.CreatedTimestamp,
 		&node.UpdatedTimestamp,
+		&node.DeletedTimestamp,
 	)
 	if err != nil {
 		return nil, err
@@ -229,6 +412,134 @@ func (r *NodeRepositoryImpl) GetAll(userId types.Snowflake) ([]*models.Node, err
 	return nodes, nil
 }
 
+// GetAllByTag is GetAll restricted to the nodes carrying a normalized tag
+func (r *NodeRepositoryImpl) GetAllByTag(userId types.Snowflake, tag string) ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetAllByTag)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(userId, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user nodes by tag: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		node, err := r.scanNodePartial(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// GetAllPage is GetAll in pages ordered by id, restricted to the nodes carrying a normalized tag when it is not empty
+func (r *NodeRepositoryImpl) GetAllPage(userId types.Snowflake, tag string, afterId types.Snowflake, limit int) ([]*models.Node, error) {
+	query := userNodesQuery + `
+		SELECT * FROM user_nodes un
+		WHERE un.id > ?`
+	args := []interface{}{userId, afterId}
+	if tag != "" {
+		query += ` AND EXISTS (
+			SELECT 1
+			FROM node_tags nt
+			INNER JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.node_id = un.id AND t.name = ?
+		)`
+		args = append(args, tag)
+	}
+	query += ` ORDER BY un.id LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.manager.Querier().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		node, err := r.scanNodePartial(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+func (r *NodeRepositoryImpl) GetChildren(parentId types.Snowflake, after models.NodeCursor, limit int) ([]*models.Node, error) {
+	return r.getLevel(stmtNodeGetChildren, parentId, after, limit)
+}
+
+func (r *NodeRepositoryImpl) GetRoots(userId types.Snowflake, after models.NodeCursor, limit int) ([]*models.Node, error) {
+	return r.getLevel(stmtNodeGetRoots, userId, after, limit)
+}
+
+func (r *NodeRepositoryImpl) getLevel(key string, id types.Snowflake, after models.NodeCursor, limit int) ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(id, after.Role, after.Name, after.Id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query child nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		var node models.Node
+		var childCount int
+		err := rows.Scan(
+			&node.Id,
+			&node.UserId,
+			&node.ParentId,
+			&node.Name,
+			&node.Description,
+			&node.Tags,
+			&node.Role,
+			&node.Color,
+			&node.Icon,
+			&node.Theme,
+			&node.Accessibility,
+			&node.Access,
+			&node.Display,
+			&node.Order,
+			&node.Size,
+			&node.Metadata,
+			&node.CreatedTimestamp,
+			&node.UpdatedTimestamp,
+			&childCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		node.ChildCount = &childCount
+		nodes = append(nodes, &node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
 func (r *NodeRepositoryImpl) GetShared(userId types.Snowflake) ([]*models.Node, error) {
 	stmt, err := r.manager.GetStatement("node_get_shared")
 	if err != nil {
@@ -286,7 +597,7 @@ func (r *NodeRepositoryImpl) loadPermissionsForNodes(userId types.Snowflake, nod
 		strings.Join(placeholders, ","))
 
 	// Prepare this query (it's dynamic so we prepare it on-the-fly)
-	stmt, err := r.db.Prepare(query)
+	stmt, err := r.manager.Querier().Prepare(query)
 	if err != nil {
 		return fmt.Errorf("failed to prepare permissions query: %w", err)
 	}
@@ -358,6 +669,34 @@ func (r *NodeRepositoryImpl) GetByID(nodeId types.Snowflake) (*models.Node, erro
 	return node, nil
 }
 
+func (r *NodeRepositoryImpl) GetSubtree(nodeId types.Snowflake) ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetSubtree)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node subtree: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		node, err := r.scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
 func (r *NodeRepositoryImpl) GetPublic(nodeId types.Snowflake) (*models.Node, error) {
 	stmt, err := r.manager.GetStatement(stmtNodeGetPublic)
 	if err != nil {
@@ -396,7 +735,33 @@ func (r *NodeRepositoryImpl) Create(node *models.Node) error {
 		return err
 	}
 
-	_, err = stmt.Exec(
+	if err := execCreateNode(stmt, node); err != nil {
+		return fmt.Errorf("failed to create node: %w", err)
+	}
+
+	return nil
+}
+
+// CreateMany inserts all nodes in a single transaction, parents must come before their children
+func (r *NodeRepositoryImpl) CreateMany(nodes []*models.Node) error {
+	stmt, err := r.manager.GetStatement(stmtNodeCreate)
+	if err != nil {
+		return err
+	}
+
+	return r.manager.Transaction(func(tx *sql.Tx) error {
+		txStmt := tx.Stmt(stmt)
+		for _, node := range nodes {
+			if err := execCreateNode(txStmt, node); err != nil {
+				return fmt.Errorf("failed to create node %d: %w", node.Id, err)
+			}
+		}
+		return nil
+	})
+}
+
+func execCreateNode(stmt *sql.Stmt, node *models.Node) error {
+	_, err := stmt.Exec(
 		node.Id,
 		node.UserId,
 		node.ParentId,
@@ -419,21 +784,46 @@ func (r *NodeRepositoryImpl) Create(node *models.Node) error {
 		node.CreatedTimestamp,
 		node.UpdatedTimestamp,
 	)
+	return err
+}
 
+func (r *NodeRepositoryImpl) Update(node *models.Node) error {
+	stmt, err := r.manager.GetStatement(stmtNodeUpdate)
 	if err != nil {
-		return fmt.Errorf("failed to create node: %w", err)
+		return err
+	}
+
+	_, err = stmt.Exec(append(updateArgs(node), node.Id)...)
+
+	if err != nil {
+		return fmt.Errorf("failed to update node: %w", err)
 	}
 
 	return nil
 }
 
-func (r *NodeRepositoryImpl) Update(node *models.Node) error {
-	stmt, err := r.manager.GetStatement(stmtNodeUpdate)
+// UpdateIfUnchanged updates a node only while its updated_timestamp is still the given one.
+// It reports false when the node was modified, or deleted, in the meantime.
+func (r *NodeRepositoryImpl) UpdateIfUnchanged(node *models.Node, updatedTimestamp int64) (bool, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeUpdateIfUnchanged)
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	result, err := stmt.Exec(append(updateArgs(node), node.Id, updatedTimestamp)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to update node: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to update node: %w", err)
 	}
+	return affected > 0, nil
+}
 
-	_, err = stmt.Exec(
+func updateArgs(node *models.Node) []interface{} {
+	return []interface{}{
 		node.ParentId,
 		node.UserId,
 		node.Name,
@@ -452,26 +842,245 @@ func (r *NodeRepositoryImpl) Update(node *models.Node) error {
 		node.ContentCompiled,
 		node.Metadata,
 		node.UpdatedTimestamp,
-		node.Id,
-	)
+	}
+}
 
+func (r *NodeRepositoryImpl) Delete(nodeId types.Snowflake) error {
+	stmt, err := r.manager.GetStatement(stmtNodeDelete)
 	if err != nil {
-		return fmt.Errorf("failed to update node: %w", err)
+		return err
+	}
+
+	_, err = stmt.Exec(nodeId)
+	if err != nil {
+		return fmt.Errorf("failed to delete node: %w", err)
 	}
 
 	return nil
 }
 
-func (r *NodeRepositoryImpl) Delete(nodeId types.Snowflake) error {
-	stmt, err := r.manager.GetStatement(stmtNodeDelete)
+func (r *NodeRepositoryImpl) GetTrash(userId types.Snowflake) ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetTrash)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trashed nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		var node models.Node
+		err := rows.Scan(
+			&node.Id,
+			&node.UserId,
+			&node.ParentId,
+			&node.Name,
+			&node.Description,
+			&node.Tags,
+			&node.Role,
+			&node.Color,
+			&node.Icon,
+			&node.Theme,
+			&node.Accessibility,
+			&node.Access,
+			&node.Display,
+			&node.Order,
+			&node.Size,
+			&node.Metadata,
+			&node.CreatedTimestamp,
+			&node.UpdatedTimestamp,
+			&node.DeletedTimestamp,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, &node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+func (r *NodeRepositoryImpl) GetTrashedByID(nodeId types.Snowflake) (*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetTrashedByID)
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := r.scanNode(stmt.QueryRow(nodeId))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trashed node by id: %w", err)
+	}
+
+	return node, nil
+}
+
+func (r *NodeRepositoryImpl) GetTrashedBefore(timestamp int64) ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetTrashedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trashed nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		node, err := r.scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// GetAllMedia returns the media nodes of every user, trashed ones included
+func (r *NodeRepositoryImpl) GetAllMedia() ([]*models.Node, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeGetAllMedia)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media nodes: %w", err)
+	}
+	defer rows.Close()
+
+	nodes := make([]*models.Node, 0)
+	for rows.Next() {
+		node, err := r.scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+
+	return nodes, nil
+}
+
+// Lock locks a node until the end of the transaction, false when it does not exist
+func (r *NodeRepositoryImpl) Lock(nodeId types.Snowflake) (bool, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeLock)
+	if err != nil {
+		return false, err
+	}
+
+	var parentId *types.Snowflake
+	err = stmt.QueryRow(nodeId).Scan(&parentId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to lock node: %w", err)
+	}
+
+	return true, nil
+}
+
+// LockAncestors locks a node and its ancestors until the end of the transaction, the ids are returned from the node up.
+// Every row is read in its latest committed state, a move committed meanwhile is seen.
+func (r *NodeRepositoryImpl) LockAncestors(nodeId types.Snowflake) ([]types.Snowflake, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeLock)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]types.Snowflake, 0)
+	seen := make(map[types.Snowflake]bool)
+	for id := &nodeId; id != nil && !seen[*id]; {
+		var parentId *types.Snowflake
+		if err := stmt.QueryRow(*id).Scan(&parentId); err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to lock node: %w", err)
+		}
+		ids = append(ids, *id)
+		seen[*id] = true
+		id = parentId
+	}
+
+	return ids, nil
+}
+
+// Move changes the parent of a node, order is left untouched when nil
+func (r *NodeRepositoryImpl) Move(nodeId types.Snowflake, parentId *types.Snowflake, order *int, timestamp int64) error {
+	stmt, err := r.manager.GetStatement(stmtNodeMove)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(parentId, order, timestamp, nodeId)
+	if err != nil {
+		return fmt.Errorf("failed to move node: %w", err)
+	}
+
+	return nil
+}
+
+// Trash moves a node and its descendants to the trash
+func (r *NodeRepositoryImpl) Trash(nodeId types.Snowflake, timestamp int64) error {
+	stmt, err := r.manager.GetStatement(stmtNodeTrash)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(nodeId, timestamp)
+	if err != nil {
+		return fmt.Errorf("failed to trash node: %w", err)
+	}
+
+	return nil
+}
+
+// Restore takes a trashed node out of the trash together with the descendants trashed along with it
+func (r *NodeRepositoryImpl) Restore(nodeId types.Snowflake) error {
+	stmt, err := r.manager.GetStatement(stmtNodeRestore)
 	if err != nil {
 		return err
 	}
 
 	_, err = stmt.Exec(nodeId)
 	if err != nil {
-		return fmt.Errorf("failed to delete node: %w", err)
+		return fmt.Errorf("failed to restore node: %w", err)
 	}
 
 	return nil
 }
+
+func (r *NodeRepositoryImpl) DeleteTrashedBefore(timestamp int64) (int64, error) {
+	stmt, err := r.manager.GetStatement(stmtNodeDeleteTrashed)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.Exec(timestamp)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete trashed nodes: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -11,6 +11,7 @@ type PermissionRepository interface {
 	GetByID(permissionId types.Snowflake) (*models.Permission, error)
 	GetByNode(nodeId types.Snowflake) ([]*models.Permission, error)
 	GetByNodeAndUser(nodeId types.Snowflake, userId types.Snowflake) (*models.Permission, error)
+	GetGrantedBy(ownerId types.Snowflake) ([]*models.Permission, error)
 	HasPermission(userId, nodeId types.Snowflake, required int) (bool, int)
 	Create(permission *models.Permission) (*models.Permission, error)
 	Update(permission *models.Permission) error
@@ -26,6 +27,7 @@ const (
 	stmtPermissionGetByID          = "permission_get_by_id"
 	stmtPermissionGetByNode        = "permission_get_by_node"
 	stmtPermissionGetByNodeAndUser = "permission_get_by_node_and_user"
+	stmtPermissionGetGrantedBy     = "permission_get_granted_by"
 	stmtPermissionCreate           = "permission_create"
 	stmtPermissionUpdate           = "permission_update"
 	stmtPermissionDelete           = "permission_delete"
@@ -61,6 +63,13 @@ func (r *PermissionRepositoryImpl) prepareStatements() error {
 			FROM permissions
 			WHERE node_id = ? AND user_id = ?`,
 
+		// permissions on the nodes of a user, trashed nodes are left out like in GetAllForBackup
+		stmtPermissionGetGrantedBy: `
+			SELECT p.id, p.node_id, p.user_id, p.permission, p.created_timestamp
+			FROM permissions p
+			INNER JOIN nodes n ON n.id = p.node_id
+			WHERE n.user_id = ? AND n.deleted_timestamp IS NULL`,
+
 		stmtPermissionCreate: `
 			INSERT INTO permissions (id, node_id, user_id, permission, created_timestamp)
 			VALUES (?, ?, ?, ?, ?)`,
@@ -110,12 +119,21 @@ func (r *PermissionRepositoryImpl) GetByID(permissionId types.Snowflake) (*model
 }
 
 func (r *PermissionRepositoryImpl) GetByNode(nodeId types.Snowflake) ([]*models.Permission, error) {
-	stmt, err := r.manager.GetStatement(stmtPermissionGetByNode)
+	return r.query(stmtPermissionGetByNode, nodeId)
+}
+
+// GetGrantedBy returns the permissions granted on the nodes of ownerId
+func (r *PermissionRepositoryImpl) GetGrantedBy(ownerId types.Snowflake) ([]*models.Permission, error) {
+	return r.query(stmtPermissionGetGrantedBy, ownerId)
+}
+
+func (r *PermissionRepositoryImpl) query(key string, id types.Snowflake) ([]*models.Permission, error) {
+	stmt, err := r.manager.GetStatement(key)
 	if err != nil {
 		return nil, err
 	}
 
-	rows, err := stmt.Query(nodeId)
+	rows, err := stmt.Query(id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query permissions: %w", err)
 	}
@@ -224,11 +242,11 @@ func (r *PermissionRepositoryImpl) Delete(permissionId types.Snowflake) error {
 
 func (r *PermissionRepositoryImpl) HasPermission(userId, nodeId types.Snowflake, required int) (bool, int) {
 	var perm sql.NullInt32
-	r.db.QueryRow(`
+	r.manager.Querier().QueryRow(`
 		WITH RECURSIVE ancestors AS (
 			SELECT id, parent_id
 			FROM nodes
-			WHERE id = ?
+			WHERE id = ? AND deleted_timestamp IS NULL
 
 			UNION ALL
 
@@ -249,11 +267,11 @@ func (r *PermissionRepositoryImpl) HasPermission(userId, nodeId types.Snowflake,
 
 	// Not enough permissions ==> check if owner of an ancestor
 	var owns int
-	err := r.db.QueryRow(`
+	err := r.manager.Querier().QueryRow(`
 		WITH RECURSIVE ancestors AS (
 			SELECT id, parent_id, user_id
 			FROM nodes
-			WHERE id = ?
+			WHERE id = ? AND deleted_timestamp IS NULL
 
 			UNION ALL
 
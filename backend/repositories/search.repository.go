@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/types"
+)
+
+// SearchRepository is the full-text search backend.
+// The default implementation relies on the MySQL FULLTEXT index of the nodes table,
+// an embedded index (e.g. Bleve) can be swapped in by implementing this interface in RepositoryManager.
+type SearchRepository interface {
+	// Search returns the nodes readable by userId matching the query, best matches first.
+	// Hits come with their node content so that snippets can be built from it.
+	Search(userId types.Snowflake, query models.SearchQuery) ([]*models.SearchHit, error)
+}
+
+type MySQLSearchRepository struct {
+	db      *sql.DB
+	manager *RepositoryManager
+}
+
+// Words shorter than innodb_ft_min_token_size are not indexed
+const mysqlMinTokenSize = 3
+
+func NewMySQLSearchRepository(db *sql.DB, manager *RepositoryManager) (SearchRepository, error) {
+	return &MySQLSearchRepository{
+		db:      db,
+		manager: manager,
+	}, nil
+}
+
+func (r *MySQLSearchRepository) Search(userId types.Snowflake, query models.SearchQuery) ([]*models.SearchHit, error) {
+	// every term is required and matched as a prefix
+	booleanTerms := make([]string, 0, len(query.Terms))
+	for _, term := range query.Terms {
+		if len([]rune(term)) >= mysqlMinTokenSize {
+			booleanTerms = append(booleanTerms, "+"+term+"*")
+		}
+	}
+	if len(booleanTerms) == 0 {
+		return []*models.SearchHit{}, nil
+	}
+	against := strings.Join(booleanTerms, " ")
+
+	// readable: owned nodes, nodes shared with the user, and everything below them (see HasPermission)
+	sqlQuery := `
+		WITH RECURSIVE readable AS (
+			SELECT id
+			FROM nodes
+			WHERE user_id = ? AND deleted_timestamp IS NULL
+
+			UNION
+
+			SELECT n.id
+			FROM nodes n
+			INNER JOIN permissions p ON p.node_id = n.id
+			WHERE p.user_id = ? AND p.permission >= 1 AND n.deleted_timestamp IS NULL
+
+			UNION
+
+			SELECT c.id
+			FROM nodes c
+			INNER JOIN readable r ON c.parent_id = r.id
+			WHERE c.deleted_timestamp IS NULL
+		)
+		SELECT n.id, n.user_id, n.parent_id, n.name, n.description, n.tags, n.role, n.color, n.icon, n.theme,
+		       n.accessibility, n.access, n.display, n.order, n.size, n.metadata, n.created_timestamp, n.updated_timestamp,
+		       n.content,
+		       MATCH(n.name, n.description, n.tags, n.content) AGAINST (? IN BOOLEAN MODE) AS score
+		FROM nodes n
+		INNER JOIN readable r ON r.id = n.id
+		WHERE MATCH(n.name, n.description, n.tags, n.content) AGAINST (? IN BOOLEAN MODE)`
+	args := []interface{}{userId, userId, against, against}
+
+	if query.Role != nil {
+		sqlQuery += ` AND n.role = ?`
+		args = append(args, *query.Role)
+	}
+	if query.Tag != nil {
+		sqlQuery += ` AND EXISTS (
+			SELECT 1
+			FROM node_tags nt
+			INNER JOIN tags t ON t.id = nt.tag_id
+			WHERE nt.node_id = n.id AND t.name = ?)`
+		args = append(args, *query.Tag)
+	}
+	if query.OwnerId != nil {
+		sqlQuery += ` AND n.user_id = ?`
+		args = append(args, *query.OwnerId)
+	}
+	if query.UpdatedAfter != nil {
+		sqlQuery += ` AND n.updated_timestamp >= ?`
+		args = append(args, *query.UpdatedAfter)
+	}
+	if query.UpdatedBefore != nil {
+		sqlQuery += ` AND n.updated_timestamp <= ?`
+		args = append(args, *query.UpdatedBefore)
+	}
+
+	sqlQuery += ` ORDER BY score DESC, n.updated_timestamp DESC LIMIT ? OFFSET ?`
+	args = append(args, query.Limit, query.Offset)
+
+	rows, err := r.manager.Querier().Query(sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search nodes: %w", err)
+	}
+	defer rows.Close()
+
+	hits := make([]*models.SearchHit, 0)
+	for rows.Next() {
+		var node models.Node
+		var hit models.SearchHit
+		err := rows.Scan(
+			&node.Id,
+			&node.UserId,
+			&node.ParentId,
+			&node.Name,
+			&node.Description,
+			&node.Tags,
+			&node.Role,
+			&node.Color,
+			&node.Icon,
+			&node.Theme,
+			&node.Accessibility,
+			&node.Access,
+			&node.Display,
+			&node.Order,
+			&node.Size,
+			&node.Metadata,
+			&node.CreatedTimestamp,
+			&node.UpdatedTimestamp,
+			&node.Content,
+			&hit.Score,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search hit: %w", err)
+		}
+		hit.Node = &node
+		hits = append(hits, &hit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating search hits: %w", err)
+	}
+
+	return hits, nil
+}
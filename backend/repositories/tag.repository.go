@@ -0,0 +1,305 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"structured-notes/models"
+	"structured-notes/types"
+)
+
+type TagRepository interface {
+	GetByUser(userId types.Snowflake) ([]*models.Tag, error)
+	GetByID(tagId types.Snowflake) (*models.Tag, error)
+	GetByName(userId types.Snowflake, name string) (*models.Tag, error)
+	SetNodeTags(nodeId types.Snowflake, tags []*models.Tag) error
+	Rename(tag *models.Tag, name string, timestamp int64) error
+	Merge(source, target *models.Tag, timestamp int64) error
+}
+
+type TagRepositoryImpl struct {
+	db      *sql.DB
+	manager *RepositoryManager
+}
+
+const (
+	stmtTagGetByUser        = "tag_get_by_user"
+	stmtTagGetByID          = "tag_get_by_id"
+	stmtTagGetByName        = "tag_get_by_name"
+	stmtTagCreate           = "tag_create"
+	stmtTagRename           = "tag_rename"
+	stmtTagDelete           = "tag_delete"
+	stmtTagGetTaggedNodes   = "tag_get_tagged_nodes"
+	stmtTagClearNode        = "tag_clear_node"
+	stmtTagAddNode          = "tag_add_node"
+	stmtTagMoveNodes        = "tag_move_nodes"
+	stmtTagUpdateNodeString = "tag_update_node_string"
+)
+
+func NewTagRepository(db *sql.DB, manager *RepositoryManager) (TagRepository, error) {
+	repo := &TagRepositoryImpl{
+		db:      db,
+		manager: manager,
+	}
+
+	if err := repo.prepareStatements(); err != nil {
+		return nil, fmt.Errorf("failed to prepare tag statements: %w", err)
+	}
+
+	return repo, nil
+}
+
+func (r *TagRepositoryImpl) prepareStatements() error {
+	statements := map[string]string{
+		// trashed nodes are not counted
+		stmtTagGetByUser: `
+			SELECT t.id, t.user_id, t.name, COUNT(n.id), t.created_timestamp
+			FROM tags t
+			LEFT JOIN node_tags nt ON nt.tag_id = t.id
+			LEFT JOIN nodes n ON n.id = nt.node_id AND n.deleted_timestamp IS NULL
+			WHERE t.user_id = ?
+			GROUP BY t.id, t.user_id, t.name, t.created_timestamp
+			ORDER BY t.name`,
+
+		stmtTagGetByID: `
+			SELECT id, user_id, name, created_timestamp
+			FROM tags
+			WHERE id = ?`,
+
+		stmtTagGetByName: `
+			SELECT id, user_id, name, created_timestamp
+			FROM tags
+			WHERE user_id = ? AND name = ?`,
+
+		// an existing tag with the same name wins, see SetNodeTags
+		stmtTagCreate: `
+			INSERT IGNORE INTO tags (id, user_id, name, created_timestamp)
+			VALUES (?, ?, ?, ?)`,
+
+		stmtTagRename: `
+			UPDATE tags SET name = ? WHERE id = ?`,
+
+		stmtTagDelete: `
+			DELETE FROM tags WHERE id = ?`,
+
+		stmtTagGetTaggedNodes: `
+			SELECT n.id, n.tags
+			FROM nodes n
+			INNER JOIN node_tags nt ON nt.node_id = n.id
+			WHERE nt.tag_id = ?`,
+
+		stmtTagClearNode: `
+			DELETE FROM node_tags WHERE node_id = ?`,
+
+		stmtTagAddNode: `
+			INSERT IGNORE INTO node_tags (node_id, tag_id)
+			SELECT ?, id FROM tags WHERE user_id = ? AND name = ?`,
+
+		stmtTagMoveNodes: `
+			INSERT IGNORE INTO node_tags (node_id, tag_id)
+			SELECT node_id, ? FROM node_tags WHERE tag_id = ?`,
+
+		// moved past the previous updated_timestamp so that the ETag of the node changes
+		stmtTagUpdateNodeString: `
+			UPDATE nodes SET tags = ?, updated_timestamp = GREATEST(?, updated_timestamp + 1) WHERE id = ?`,
+	}
+
+	for key, query := range statements {
+		if _, err := r.manager.PrepareStatement(key, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *TagRepositoryImpl) GetByUser(userId types.Snowflake) ([]*models.Tag, error) {
+	stmt, err := r.manager.GetStatement(stmtTagGetByUser)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(userId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := make([]*models.Tag, 0)
+	for rows.Next() {
+		var tag models.Tag
+		if err := rows.Scan(&tag.Id, &tag.UserId, &tag.Name, &tag.Count, &tag.CreatedTimestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, &tag)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+
+	return tags, nil
+}
+
+func (r *TagRepositoryImpl) GetByID(tagId types.Snowflake) (*models.Tag, error) {
+	stmt, err := r.manager.GetStatement(stmtTagGetByID)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanTag(stmt.QueryRow(tagId))
+}
+
+func (r *TagRepositoryImpl) GetByName(userId types.Snowflake, name string) (*models.Tag, error) {
+	stmt, err := r.manager.GetStatement(stmtTagGetByName)
+	if err != nil {
+		return nil, err
+	}
+	return r.scanTag(stmt.QueryRow(userId, name))
+}
+
+func (r *TagRepositoryImpl) scanTag(row *sql.Row) (*models.Tag, error) {
+	var tag models.Tag
+	err := row.Scan(&tag.Id, &tag.UserId, &tag.Name, &tag.CreatedTimestamp)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tag: %w", err)
+	}
+	return &tag, nil
+}
+
+// SetNodeTags replaces the tags of a node. Missing tags are created,
+// tags already existing under the same user and name keep their id.
+func (r *TagRepositoryImpl) SetNodeTags(nodeId types.Snowflake, tags []*models.Tag) error {
+	return r.manager.Transaction(func(tx *sql.Tx) error {
+		create, err := r.txStmt(tx, stmtTagCreate)
+		if err != nil {
+			return err
+		}
+		clearNode, err := r.txStmt(tx, stmtTagClearNode)
+		if err != nil {
+			return err
+		}
+		add, err := r.txStmt(tx, stmtTagAddNode)
+		if err != nil {
+			return err
+		}
+
+		if _, err := clearNode.Exec(nodeId); err != nil {
+			return fmt.Errorf("failed to clear node tags: %w", err)
+		}
+
+		for _, tag := range tags {
+			if _, err := create.Exec(tag.Id, tag.UserId, tag.Name, tag.CreatedTimestamp); err != nil {
+				return fmt.Errorf("failed to create tag: %w", err)
+			}
+			if _, err := add.Exec(nodeId, tag.UserId, tag.Name); err != nil {
+				return fmt.Errorf("failed to tag node: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Rename renames a tag and rewrites the tags of the nodes carrying it
+func (r *TagRepositoryImpl) Rename(tag *models.Tag, name string, timestamp int64) error {
+	return r.manager.Transaction(func(tx *sql.Tx) error {
+		if err := r.rewriteNodeTags(tx, tag, name, timestamp); err != nil {
+			return err
+		}
+
+		rename, err := r.txStmt(tx, stmtTagRename)
+		if err != nil {
+			return err
+		}
+		if _, err := rename.Exec(name, tag.Id); err != nil {
+			return fmt.Errorf("failed to rename tag: %w", err)
+		}
+		return nil
+	})
+}
+
+// Merge moves the nodes of source to target and deletes source
+func (r *TagRepositoryImpl) Merge(source, target *models.Tag, timestamp int64) error {
+	return r.manager.Transaction(func(tx *sql.Tx) error {
+		if err := r.rewriteNodeTags(tx, source, target.Name, timestamp); err != nil {
+			return err
+		}
+
+		move, err := r.txStmt(tx, stmtTagMoveNodes)
+		if err != nil {
+			return err
+		}
+		del, err := r.txStmt(tx, stmtTagDelete)
+		if err != nil {
+			return err
+		}
+		if _, err := move.Exec(target.Id, source.Id); err != nil {
+			return fmt.Errorf("failed to move tagged nodes: %w", err)
+		}
+		// node_tags rows of source go with it
+		if _, err := del.Exec(source.Id); err != nil {
+			return fmt.Errorf("failed to delete merged tag: %w", err)
+		}
+		return nil
+	})
+}
+
+// rewriteNodeTags replaces the name of tag in the comma separated tags of the nodes carrying it
+func (r *TagRepositoryImpl) rewriteNodeTags(tx *sql.Tx, tag *models.Tag, name string, timestamp int64) error {
+	query, err := r.txStmt(tx, stmtTagGetTaggedNodes)
+	if err != nil {
+		return err
+	}
+	update, err := r.txStmt(tx, stmtTagUpdateNodeString)
+	if err != nil {
+		return err
+	}
+
+	rows, err := query.Query(tag.Id)
+	if err != nil {
+		return fmt.Errorf("failed to query tagged nodes: %w", err)
+	}
+
+	nodeTags := make(map[types.Snowflake]*string)
+	for rows.Next() {
+		var nodeId types.Snowflake
+		var tags *string
+		if err := rows.Scan(&nodeId, &tags); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan tagged node: %w", err)
+		}
+		nodeTags[nodeId] = tags
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating tagged nodes: %w", err)
+	}
+
+	for nodeId, tags := range nodeTags {
+		// a merge may leave the target name twice
+		rewritten := make([]string, 0)
+		for _, t := range models.ParseTags(tags) {
+			if t == tag.Name {
+				t = name
+			}
+			if !slices.Contains(rewritten, t) {
+				rewritten = append(rewritten, t)
+			}
+		}
+
+		if _, err := update.Exec(models.JoinTags(rewritten), timestamp, nodeId); err != nil {
+			return fmt.Errorf("failed to update node tags: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *TagRepositoryImpl) txStmt(tx *sql.Tx, key string) (*sql.Stmt, error) {
+	stmt, err := r.manager.GetStatement(key)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Stmt(stmt), nil
+}
@@ -0,0 +1,197 @@
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"structured-notes/models"
+	"structured-notes/types"
+)
+
+type VersionRepository interface {
+	GetByNode(nodeId types.Snowflake) ([]*models.NodeVersion, error)
+	GetByID(versionId types.Snowflake) (*models.NodeVersion, error)
+	Create(version *models.NodeVersion) error
+	Prune(nodeId types.Snowflake, maxCount int, olderThan int64) error
+}
+
+type VersionRepositoryImpl struct {
+	db      *sql.DB
+	manager *RepositoryManager
+}
+
+const (
+	stmtVersionGetByNode       = "version_get_by_node"
+	stmtVersionGetByID         = "version_get_by_id"
+	stmtVersionCreate          = "version_create"
+	stmtVersionDeleteExceeding = "version_delete_exceeding"
+	stmtVersionDeleteOlderThan = "version_delete_older_than"
+)
+
+func NewVersionRepository(db *sql.DB, manager *RepositoryManager) (VersionRepository, error) {
+	repo := &VersionRepositoryImpl{
+		db:      db,
+		manager: manager,
+	}
+
+	if err := repo.prepareStatements(); err != nil {
+		return nil, fmt.Errorf("failed to prepare version statements: %w", err)
+	}
+
+	return repo, nil
+}
+
+func (r *VersionRepositoryImpl) prepareStatements() error {
+	statements := map[string]string{
+		// content is left out of listings, it is loaded per version
+		stmtVersionGetByNode: `
+			SELECT id, node_id, user_id, name, created_timestamp
+			FROM node_versions
+			WHERE node_id = ?
+			ORDER BY created_timestamp DESC, id DESC`,
+
+		stmtVersionGetByID: `
+			SELECT id, node_id, user_id, name, content, content_compiled, created_timestamp
+			FROM node_versions
+			WHERE id = ?`,
+
+		stmtVersionCreate: `
+			INSERT INTO node_versions (id, node_id, user_id, name, content, content_compiled, created_timestamp)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+
+		// MySQL does not support LIMIT inside IN subqueries, hence the derived table
+		stmtVersionDeleteExceeding: `
+			DELETE FROM node_versions
+			WHERE node_id = ? AND id NOT IN (
+				SELECT id FROM (
+					SELECT id
+					FROM node_versions
+					WHERE node_id = ?
+					ORDER BY created_timestamp DESC, id DESC
+					LIMIT ?
+				) AS kept
+			)`,
+
+		stmtVersionDeleteOlderThan: `
+			DELETE FROM node_versions
+			WHERE node_id = ? AND created_timestamp < ?`,
+	}
+
+	for key, query := range statements {
+		if _, err := r.manager.PrepareStatement(key, query); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *VersionRepositoryImpl) GetByNode(nodeId types.Snowflake) ([]*models.NodeVersion, error) {
+	stmt, err := r.manager.GetStatement(stmtVersionGetByNode)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(nodeId)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query node versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make([]*models.NodeVersion, 0)
+	for rows.Next() {
+		var version models.NodeVersion
+		err := rows.Scan(
+			&version.Id,
+			&version.NodeId,
+			&version.UserId,
+			&version.Name,
+			&version.CreatedTimestamp,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan node version: %w", err)
+		}
+		versions = append(versions, &version)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating node versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+func (r *VersionRepositoryImpl) GetByID(versionId types.Snowflake) (*models.NodeVersion, error) {
+	stmt, err := r.manager.GetStatement(stmtVersionGetByID)
+	if err != nil {
+		return nil, err
+	}
+
+	var version models.NodeVersion
+	err = stmt.QueryRow(versionId).Scan(
+		&version.Id,
+		&version.NodeId,
+		&version.UserId,
+		&version.Name,
+		&version.Content,
+		&version.ContentCompiled,
+		&version.CreatedTimestamp,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node version: %w", err)
+	}
+
+	return &version, nil
+}
+
+func (r *VersionRepositoryImpl) Create(version *models.NodeVersion) error {
+	stmt, err := r.manager.GetStatement(stmtVersionCreate)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(
+		version.Id,
+		version.NodeId,
+		version.UserId,
+		version.Name,
+		version.Content,
+		version.ContentCompiled,
+		version.CreatedTimestamp,
+	)
+
+	if err != nil {
+		return fmt.Errorf("failed to create node version: %w", err)
+	}
+
+	return nil
+}
+
+// Prune keeps at most maxCount versions of a node and drops the ones created before olderThan.
+// A zero maxCount or olderThan disables the matching rule.
+func (r *VersionRepositoryImpl) Prune(nodeId types.Snowflake, maxCount int, olderThan int64) error {
+	if maxCount > 0 {
+		stmt, err := r.manager.GetStatement(stmtVersionDeleteExceeding)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(nodeId, nodeId, maxCount); err != nil {
+			return fmt.Errorf("failed to delete exceeding node versions: %w", err)
+		}
+	}
+
+	if olderThan > 0 {
+		stmt, err := r.manager.GetStatement(stmtVersionDeleteOlderThan)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(nodeId, olderThan); err != nil {
+			return fmt.Errorf("failed to delete old node versions: %w", err)
+		}
+	}
+
+	return nil
+}
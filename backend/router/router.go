@@ -18,8 +18,8 @@ func InitRouter(app *app.App) *gin.Engine {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{os.Getenv("DOMAIN_CLIENT")},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept"},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "ETag", "Upload-Offset"},
+		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization", "Accept", "If-Match", "If-None-Match", "Upload-Offset"},
 		AllowCredentials: true,
 	}))
 
@@ -30,5 +30,12 @@ func InitRouter(app *app.App) *gin.Engine {
 	routes.Uploads(app, mainGroup, mediaGroup)
 	routes.Nodes(app, mainGroup)
 	routes.Permissions(app, mainGroup)
+	routes.Versions(app, mainGroup)
+	routes.Search(app, mainGroup)
+	routes.Tags(app, mainGroup)
+	routes.Links(app, mainGroup)
+	routes.Graph(app, mainGroup)
+	routes.Import(app, mainGroup)
+	routes.Export(app, mainGroup)
 	return router
 }
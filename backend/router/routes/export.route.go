@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Export(app *app.App, router *gin.RouterGroup) {
+	node := router.Group("/nodes")
+	exportCtrl := controllers.NewExportController(app)
+
+	// :userId holds the node id, see GET /nodes/:userId/:id
+	node.GET("/:userId/export", middlewares.Auth(), exportCtrl.ExportNode)
+}
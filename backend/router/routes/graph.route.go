@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Graph(app *app.App, router *gin.RouterGroup) {
+	node := router.Group("/nodes")
+	graphCtrl := controllers.NewGraphController(app)
+
+	// :userId holds the node id, see GET /nodes/:userId/:id
+	node.GET("/:userId/graph", middlewares.Auth(), utils.ResponseFormatter(graphCtrl.GetGraph))
+}
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Import(app *app.App, router *gin.RouterGroup) {
+	importCtrl := controllers.NewImportController(app)
+
+	router.POST("/import", middlewares.Auth(), utils.ResponseFormatter(importCtrl.Import))
+}
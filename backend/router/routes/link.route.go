@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Links(app *app.App, router *gin.RouterGroup) {
+	node := router.Group("/nodes")
+	linkCtrl := controllers.NewLinkController(app)
+
+	// :userId holds the node id, see GET /nodes/:userId/:id
+	node.GET("/:userId/backlinks", middlewares.Auth(), utils.ResponseFormatter(linkCtrl.GetBacklinks))
+	node.GET("/:userId/outlinks", middlewares.Auth(), utils.ResponseFormatter(linkCtrl.GetOutlinks))
+}
@@ -15,14 +15,25 @@ func Uploads(app *app.App, mainGroup *gin.RouterGroup, mediaGroup *gin.RouterGro
 	mediaCtrl := controllers.NewMediaController(app)
 
 	media.Use(middlewares.Auth())
-	media.GET(("/backup"), utils.ResponseFormatter(mediaCtrl.GetBackup))
+	media.GET(("/backup"), mediaCtrl.GetBackup)
+	media.POST("/restore", utils.ResponseFormatter(mediaCtrl.RestoreBackup))
+	media.GET("/backups", utils.ResponseFormatter(mediaCtrl.GetStoredBackups))
+	media.GET("/backups/:name", mediaCtrl.DownloadStoredBackup)
 	media.POST("", utils.ResponseFormatter(mediaCtrl.UploadFile))
 	media.POST("/avatar", utils.ResponseFormatter(mediaCtrl.UploadAvatar))
+	// resumable uploads: created, sent in chunks, then completed into a media node
+	media.POST("/uploads", utils.ResponseFormatter(mediaCtrl.CreateUpload))
+	media.GET("/uploads/:id", utils.ResponseFormatter(mediaCtrl.GetUpload))
+	media.PATCH("/uploads/:id", utils.ResponseFormatter(mediaCtrl.WriteUploadChunk))
+	media.POST("/uploads/:id/complete", utils.ResponseFormatter(mediaCtrl.CompleteUpload))
+	media.DELETE("/uploads/:id", utils.ResponseFormatter(mediaCtrl.AbortUpload))
 	media.DELETE("/:id", utils.ResponseFormatter(mediaCtrl.DeleteUpload))
 
 	// /media
 	// Processes GET from for example <img src="[serverUrl]/media/[userId]/[nodeId].png">
 	mediaUploads := mediaGroup
+	// avatars show on public profiles, the route comes before the Auth middleware
+	mediaUploads.GET("/avatars/:userId", mediaCtrl.GetAvatar)
 	mediaUploads.Use(middlewares.Auth())
 	mediaUploads.GET("/:userId/:nameAndExt", mediaCtrl.GetMediaFile)
 }
@@ -15,9 +15,17 @@ func Nodes(app *app.App, router *gin.RouterGroup) {
 
 	node.GET("/public/:id", utils.ResponseFormatter(nodeCtrl.GetPublicNode))
 	node.GET("/shared/:userId", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.GetSharedNodes))
+	node.GET("/trash/:userId", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.GetTrash))
 	node.GET("/:userId", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.GetNodes))
+	node.GET("/:userId/children/:parentId", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.GetChildren))
 	node.GET("/:userId/:id", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.GetNode))
 	node.POST("", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.CreateNode))
+	node.POST("/batch", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.BatchNodes))
+	node.POST("/from-template/:templateId", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.InstantiateTemplate))
+	node.POST("/:id/duplicate", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.DuplicateNode))
+	node.POST("/:id/move", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.MoveNode))
+	node.POST("/:id/restore", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.RestoreNode))
 	node.PUT("/:id", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.UpdateNode))
+	node.PATCH("/:id", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.PatchNode))
 	node.DELETE("/:id", middlewares.Auth(), utils.ResponseFormatter(nodeCtrl.DeleteNode))
 }
@@ -0,0 +1,16 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Search(app *app.App, router *gin.RouterGroup) {
+	searchCtrl := controllers.NewSearchController(app)
+
+	router.GET("/search", middlewares.Auth(), utils.ResponseFormatter(searchCtrl.Search))
+}
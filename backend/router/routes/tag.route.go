@@ -0,0 +1,18 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Tags(app *app.App, router *gin.RouterGroup) {
+	tags := router.Group("/tags")
+	tagCtrl := controllers.NewTagController(app)
+
+	tags.GET("/:userId", middlewares.Auth(), utils.ResponseFormatter(tagCtrl.GetTags))
+	tags.PATCH("/:id", middlewares.Auth(), utils.ResponseFormatter(tagCtrl.RenameTag))
+}
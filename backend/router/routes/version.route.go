@@ -0,0 +1,21 @@
+package routes
+
+import (
+	"structured-notes/app"
+	"structured-notes/controllers"
+	"structured-notes/middlewares"
+	"structured-notes/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func Versions(app *app.App, router *gin.RouterGroup) {
+	node := router.Group("/nodes")
+	versionCtrl := controllers.NewVersionController(app)
+
+	// :userId holds the node id, see GET /nodes/:userId/:id
+	node.GET("/:userId/versions", middlewares.Auth(), utils.ResponseFormatter(versionCtrl.GetVersions))
+	node.GET("/:userId/versions/:versionId", middlewares.Auth(), utils.ResponseFormatter(versionCtrl.GetVersion))
+	node.GET("/:userId/versions/:versionId/diff", middlewares.Auth(), utils.ResponseFormatter(versionCtrl.DiffVersions))
+	node.POST("/:id/versions/:versionId/restore", middlewares.Auth(), utils.ResponseFormatter(versionCtrl.RestoreVersion))
+}
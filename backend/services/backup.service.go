@@ -0,0 +1,704 @@
+package services
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"structured-notes/logger"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/storage"
+	"structured-notes/types"
+	"structured-notes/utils"
+	"time"
+)
+
+const (
+	backupManifestFile    = "manifest.json"
+	backupNodesFile       = "nodes.json"
+	backupPermissionsFile = "permissions.json"
+	backupMediaFolder     = "media"
+)
+
+var ErrInvalidBackup = errors.New("invalid backup archive")
+
+type BackupService interface {
+	// GetBackup loads the nodes of a user and the permissions granted on them, see Backup.WriteZip
+	GetBackup(userId types.Snowflake) (*Backup, error)
+	// Restore imports a backup archive into the account of userId, under fresh ids
+	Restore(archive io.ReaderAt, size int64, userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, maxUploadsSize float64) (*models.RestoreReport, error)
+	// RunScheduledBackups backs up the users whose last stored backup is older than the schedule interval
+	RunScheduledBackups(schedule BackupSchedule) (int, error)
+	GetStoredBackups(userId types.Snowflake, schedule BackupSchedule, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.StoredBackup, error)
+	// GetStoredBackupPath returns the file of a stored backup, to be sent as is
+	GetStoredBackupPath(userId types.Snowflake, name string, schedule BackupSchedule, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (string, error)
+}
+
+// BackupSchedule configures the backups stored by the server, see the [Backups] configuration
+type BackupSchedule struct {
+	Directory  string
+	Interval   int // hours between two backups of a user; 0: disabled
+	KeepLast   int // most recent backups kept
+	KeepDaily  int // days for which the last backup of the day is kept
+	KeepWeekly int // weeks for which the last backup of the week is kept
+}
+
+type backupService struct {
+	repos     *repositories.RepositoryManager
+	storage   storage.Storage
+	snowflake *utils.Snowflake
+}
+
+func NewBackupService(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) BackupService {
+	return &backupService{
+		repos:     repos,
+		storage:   store,
+		snowflake: snowflake,
+	}
+}
+
+// Backup is the content of a user backup, ready to be written as a ZIP archive
+type Backup struct {
+	Manifest    *models.BackupManifest
+	nodes       []*models.Node
+	permissions []*models.Permission
+	storage     storage.Storage
+}
+
+func (s *backupService) GetBackup(userId types.Snowflake) (*Backup, error) {
+	nodes, err := s.repos.Node.GetAllForBackup(userId)
+	if err != nil {
+		return nil, err
+	}
+	perms, err := s.repos.Permission.GetGrantedBy(userId)
+	if err != nil {
+		return nil, err
+	}
+	schemaVersion, err := s.repos.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backup{
+		Manifest: &models.BackupManifest{
+			FormatVersion:    models.BackupFormatVersion,
+			SchemaVersion:    schemaVersion,
+			UserId:           userId,
+			CreatedTimestamp: time.Now().UnixMilli(),
+			Nodes:            len(nodes),
+			Permissions:      len(perms),
+			Media:            make([]*models.BackupMediaFile, 0),
+		},
+		nodes:       nodes,
+		permissions: perms,
+		storage:     s.storage,
+	}, nil
+}
+
+// WriteZip streams the archive to w. The manifest comes last, once the checksums of the media files are known.
+// Media files missing from the storage are left out of it.
+func (b *Backup) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	if err := writeZipJSON(archive, backupNodesFile, b.nodes); err != nil {
+		return err
+	}
+	if err := writeZipJSON(archive, backupPermissionsFile, b.permissions); err != nil {
+		return err
+	}
+
+	for _, node := range b.nodes {
+		if node.Role != models.NodeRoleMedia {
+			continue
+		}
+		media, err := writeBackupMediaFile(archive, b.storage, node)
+		if err != nil {
+			return err
+		}
+		if media != nil {
+			b.Manifest.Media = append(b.Manifest.Media, media)
+		}
+	}
+
+	if err := writeZipJSON(archive, backupManifestFile, b.Manifest); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func writeZipJSON(archive *zip.Writer, name string, value any) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := json.NewEncoder(file).Encode(value); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeBackupMediaFile(archive *zip.Writer, store storage.Storage, node *models.Node) (*models.BackupMediaFile, error) {
+	filename, ok := mediaFileName(node)
+	if !ok {
+		return nil, nil
+	}
+	src, _, err := store.Get(filename)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Media file %s not backed up: %v", filename, err))
+		return nil, nil
+	}
+	defer src.Close()
+
+	name := path.Join(backupMediaFolder, path.Base(filename))
+	// media are compressed already
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.UnixMilli(node.UpdatedTimestamp)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy media file: %w", err)
+	}
+	return &models.BackupMediaFile{
+		NodeId: node.Id,
+		Path:   name,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Restore imports a backup into the account of userId. Nodes, permissions and media get fresh ids,
+// parents and links between restored nodes are remapped, parents missing from the backup leave nodes at the root.
+// The media of the manifest must fit in the remaining quota before any is read, each file is then checked
+// against its checksum while it is stored, one at a time.
+func (s *backupService) Restore(archive io.ReaderAt, size int64, userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, maxUploadsSize float64) (*models.RestoreReport, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+	user, err := s.repos.User.GetByID(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	totalSize, err := s.repos.Node.GetUserUploadsSize(userId)
+	if err != nil {
+		return nil, err
+	}
+	backup, entries, err := readBackup(archive, size, int64(maxUploadsSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var mediaSize int64
+	for _, media := range backup.Manifest.Media {
+		mediaSize += media.Size
+	}
+	if mediaSize > int64(maxUploadsSize)-totalSize {
+		return nil, errors.New("total size of uploads exceeds the limit")
+	}
+
+	mediaFiles := make(map[types.Snowflake]*models.BackupMediaFile, len(backup.Manifest.Media))
+	for _, media := range backup.Manifest.Media {
+		mediaFiles[media.NodeId] = media
+	}
+
+	// media whose file was missing when the backup was taken, or of an unknown type, are left out
+	nodes := make([]*models.Node, 0, len(backup.nodes))
+	for _, node := range backup.nodes {
+		_, hasFile := mediaFiles[node.Id]
+		_, hasType := mediaFileName(node)
+		if node.Role != models.NodeRoleMedia || (hasFile && hasType) {
+			nodes = append(nodes, node)
+		}
+	}
+	nodes, err = sortParentsFirst(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.RestoreReport{UserId: userId, RootIds: make([]types.Snowflake, 0)}
+	newIds := make(map[types.Snowflake]types.Snowflake, len(nodes))
+	for _, node := range nodes {
+		newIds[node.Id] = s.snowflake.Generate()
+	}
+
+	savedFiles := make([]string, 0)
+	removeSavedFiles := func() {
+		for _, filename := range savedFiles {
+			if err := removeMediaFile(s.storage, filename); err != nil {
+				logger.Error(fmt.Sprintf("Error removing media file %s: %v", filename, err))
+			}
+		}
+	}
+
+	restored := make([]*models.Node, 0, len(nodes))
+	mediaLinks := make([]string, 0)
+	for _, node := range nodes {
+		copied := *node
+		copied.Id = newIds[node.Id]
+		copied.UserId = userId
+		copied.DeletedTimestamp = nil
+		copied.Permissions = nil
+		copied.ChildCount = nil
+		copied.ParentId = nil
+		if node.ParentId != nil {
+			if newParentId, ok := newIds[*node.ParentId]; ok {
+				copied.ParentId = &newParentId
+			}
+		}
+		if copied.ParentId == nil {
+			report.RootIds = append(report.RootIds, copied.Id)
+		}
+
+		if node.Role == models.NodeRoleMedia {
+			media := mediaFiles[node.Id]
+			filename, _ := mediaFileName(node)
+			transformedPath := fmt.Sprintf("%d%s", copied.Id, path.Ext(filename))
+			newFilename := path.Join(fmt.Sprintf("%d", userId), transformedPath)
+			mimeType, _ := node.Metadata.GetString("filetype")
+			content, err := restoreMediaFile(s.storage, entries[media.Path], media, newFilename, slices.Contains(imageVariantTypes, mimeType))
+			if err != nil {
+				removeSavedFiles()
+				return nil, err
+			}
+			savedFiles = append(savedFiles, newFilename)
+			report.Media++
+
+			metadata := types.JSONB{}
+			if node.Metadata != nil {
+				for key, value := range *node.Metadata {
+					metadata[key] = value
+				}
+			}
+			metadata["transformed_path"] = transformedPath
+			metadata["sha256"] = media.SHA256
+			delete(metadata, "variants")
+			copied.Metadata = &metadata
+			copied.ContentCompiled = &transformedPath
+			copied.Thumbnail = nil
+
+			// variants are not part of backups, they are generated again
+			variantFiles, err := saveImageVariants(s.storage, &copied, content)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Variants of %s not generated: %v", newFilename, err))
+			}
+			savedFiles = append(savedFiles, variantFiles...)
+
+			// documents embed media as /media/[userId]/[nodeId].ext
+			mediaLinks = append(mediaLinks, filename, newFilename)
+		}
+		restored = append(restored, &copied)
+	}
+
+	mediaReplacer := strings.NewReplacer(mediaLinks...)
+	for _, node := range restored {
+		if node.Role == models.NodeRoleMedia {
+			continue
+		}
+		if node.Content != nil {
+			content := utils.ReplaceNodeLinks(mediaReplacer.Replace(*node.Content), newIds)
+			node.Content = &content
+		}
+		if node.ContentCompiled != nil {
+			contentCompiled := utils.ReplaceNodeLinks(mediaReplacer.Replace(*node.ContentCompiled), newIds)
+			node.ContentCompiled = &contentCompiled
+		}
+	}
+
+	err = s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		if err := repos.Node.CreateMany(restored); err != nil {
+			return err
+		}
+		nodeService := newNodeService(repos, s.storage, s.snowflake)
+		for _, node := range restored {
+			if err := nodeService.setNodeTags(node.Id, userId, node.Tags); err != nil {
+				return err
+			}
+			if err := saveNodeLinks(repos.Link, node); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now().UnixMilli()
+		grantees := make(map[types.Snowflake]bool)
+		for _, perm := range backup.permissions {
+			nodeId, ok := newIds[perm.NodeId]
+			if !ok || perm.UserId == userId {
+				continue
+			}
+			exists, known := grantees[perm.UserId]
+			if !known {
+				grantee, err := repos.User.GetByID(perm.UserId)
+				if err != nil {
+					return err
+				}
+				exists = grantee != nil
+				grantees[perm.UserId] = exists
+			}
+			if !exists {
+				continue
+			}
+
+			if _, err := repos.Permission.Create(&models.Permission{
+				Id:               s.snowflake.Generate(),
+				NodeId:           nodeId,
+				UserId:           perm.UserId,
+				Permission:       perm.Permission,
+				CreatedTimestamp: now,
+			}); err != nil {
+				return err
+			}
+			report.Permissions++
+		}
+		return nil
+	})
+	if err != nil {
+		removeSavedFiles()
+		return nil, err
+	}
+
+	report.Nodes = len(restored)
+	return report, nil
+}
+
+// readBackup reads and checks a backup archive, no file may be larger than maxSize once inflated.
+// Media files are left in the archive, returned with its entries by path, their checksums are checked when restored.
+func readBackup(archive io.ReaderAt, size int64, maxSize int64) (*Backup, map[string]*zip.File, error) {
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	entries := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		entries[file.Name] = file
+	}
+
+	backup := &Backup{}
+	if err := readZipJSON(entries, backupManifestFile, maxSize, &backup.Manifest); err != nil {
+		return nil, nil, err
+	}
+	if backup.Manifest == nil || backup.Manifest.FormatVersion < 1 {
+		return nil, nil, fmt.Errorf("%w: missing format version", ErrInvalidBackup)
+	}
+	if backup.Manifest.FormatVersion > models.BackupFormatVersion {
+		return nil, nil, fmt.Errorf("%w: format version %d is newer than the supported %d", ErrInvalidBackup, backup.Manifest.FormatVersion, models.BackupFormatVersion)
+	}
+
+	if err := readZipJSON(entries, backupNodesFile, maxSize, &backup.nodes); err != nil {
+		return nil, nil, err
+	}
+	if err := readZipJSON(entries, backupPermissionsFile, maxSize, &backup.permissions); err != nil {
+		return nil, nil, err
+	}
+	if len(backup.nodes) != backup.Manifest.Nodes || len(backup.permissions) != backup.Manifest.Permissions {
+		return nil, nil, fmt.Errorf("%w: content does not match the manifest", ErrInvalidBackup)
+	}
+
+	for _, media := range backup.Manifest.Media {
+		file, ok := entries[media.Path]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, media.Path)
+		}
+		if media.Size < 0 || media.Size > maxSize || file.UncompressedSize64 != uint64(media.Size) {
+			return nil, nil, fmt.Errorf("%w: %s does not match its size", ErrInvalidBackup, media.Path)
+		}
+	}
+	return backup, entries, nil
+}
+
+// restoreMediaFile stores a media file of a backup under key once inflated, and removes it again when it does not
+// match the size and checksum of the manifest. The content is returned when keep is set, for variants to be generated.
+func restoreMediaFile(store storage.Storage, file *zip.File, media *models.BackupMediaFile, key string, keep bool) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(io.LimitReader(reader, media.Size), hash)}
+	var content []byte
+	if keep {
+		content, err = io.ReadAll(counter)
+		if err == nil {
+			err = saveMediaFile(store, content, key)
+		}
+	} else {
+		err = store.Put(key, counter, media.Size)
+	}
+	if err == nil && (counter.n != media.Size || hex.EncodeToString(hash.Sum(nil)) != media.SHA256) {
+		err = fmt.Errorf("%w: %s does not match its checksum", ErrInvalidBackup, media.Path)
+	}
+	if err != nil {
+		if removeErr := removeMediaFile(store, key); removeErr != nil {
+			logger.Error(fmt.Sprintf("Error removing media file %s: %v", key, removeErr))
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func readZipJSON(entries map[string]*zip.File, name string, maxSize int64, value any) error {
+	data, err := readZipFile(entries, name, maxSize)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidBackup, name, err)
+	}
+	return nil
+}
+
+func readZipFile(entries map[string]*zip.File, name string, maxSize int64) ([]byte, error) {
+	file, ok := entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is missing", ErrInvalidBackup, name)
+	}
+	if file.UncompressedSize64 > uint64(maxSize) {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidBackup, name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalidBackup, name)
+	}
+	return data, nil
+}
+
+// sortParentsFirst orders nodes so that parents come before their children,
+// nodes whose parent is not part of the list come first
+func sortParentsFirst(nodes []*models.Node) ([]*models.Node, error) {
+	present := make(map[types.Snowflake]bool, len(nodes))
+	for _, node := range nodes {
+		present[node.Id] = true
+	}
+
+	children := make(map[types.Snowflake][]*models.Node)
+	sorted := make([]*models.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if node.ParentId != nil && present[*node.ParentId] {
+			children[*node.ParentId] = append(children[*node.ParentId], node)
+		} else {
+			sorted = append(sorted, node)
+		}
+	}
+	for i := 0; i < len(sorted); i++ {
+		sorted = append(sorted, children[sorted[i].Id]...)
+	}
+
+	if len(sorted) != len(nodes) {
+		return nil, fmt.Errorf("%w: nodes form a cycle", ErrInvalidBackup)
+	}
+	return sorted, nil
+}
+
+// stored backups are named after their creation time, in UTC
+const storedBackupLayout = "backup-20060102-150405.zip"
+
+func (s *backupService) RunScheduledBackups(schedule BackupSchedule) (int, error) {
+	if schedule.Interval <= 0 {
+		return 0, nil
+	}
+	users, err := s.repos.User.GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	created := 0
+	errs := make([]error, 0)
+	for _, user := range users {
+		stored, err := listStoredBackups(schedule.Directory, user.Id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// a restart does not trigger a new backup
+		if len(stored) > 0 && now.Sub(time.UnixMilli(stored[0].CreatedTimestamp)) < time.Duration(schedule.Interval)*time.Hour {
+			continue
+		}
+
+		if err := s.storeBackup(schedule.Directory, user.Id, now); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", user.Id, err))
+			continue
+		}
+		created++
+
+		if err := pruneStoredBackups(schedule, user.Id); err != nil {
+			errs = append(errs, fmt.Errorf("user %d: %w", user.Id, err))
+		}
+	}
+	return created, errors.Join(errs...)
+}
+
+// storeBackup writes the backup of a user next to its final name first, so that listings never see a partial archive
+func (s *backupService) storeBackup(directory string, userId types.Snowflake, now time.Time) error {
+	backup, err := s.GetBackup(userId)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(directory, fmt.Sprintf("%d", userId))
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	fullPath := filepath.Join(dir, now.UTC().Format(storedBackupLayout))
+
+	file, err := os.Create(fullPath + ".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	if err := backup.WriteZip(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write backup file: %w", err)
+	}
+	return os.Rename(file.Name(), fullPath)
+}
+
+func (s *backupService) GetStoredBackups(userId types.Snowflake, schedule BackupSchedule, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.StoredBackup, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+	return listStoredBackups(schedule.Directory, userId)
+}
+
+func (s *backupService) GetStoredBackupPath(userId types.Snowflake, name string, schedule BackupSchedule, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (string, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return "", errors.New("unauthorized")
+	}
+	// the name is checked against the layout, it cannot leave the directory of the user
+	if _, err := time.Parse(storedBackupLayout, name); err != nil {
+		return "", errors.New("backup not found")
+	}
+
+	fullPath := filepath.Join(schedule.Directory, fmt.Sprintf("%d", userId), name)
+	if _, err := os.Stat(fullPath); err != nil {
+		return "", errors.New("backup not found")
+	}
+	return fullPath, nil
+}
+
+// listStoredBackups returns the stored backups of a user, most recent first
+func listStoredBackups(directory string, userId types.Snowflake) ([]*models.StoredBackup, error) {
+	entries, err := os.ReadDir(filepath.Join(directory, fmt.Sprintf("%d", userId)))
+	if os.IsNotExist(err) {
+		return []*models.StoredBackup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	backups := make([]*models.StoredBackup, 0, len(entries))
+	for _, entry := range entries {
+		created, err := time.Parse(storedBackupLayout, entry.Name())
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, &models.StoredBackup{
+			Name:             entry.Name(),
+			Size:             info.Size(),
+			CreatedTimestamp: created.UnixMilli(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedTimestamp > backups[j].CreatedTimestamp
+	})
+	return backups, nil
+}
+
+// pruneStoredBackups deletes the backups of a user kept by none of the retention rules
+func pruneStoredBackups(schedule BackupSchedule, userId types.Snowflake) error {
+	backups, err := listStoredBackups(schedule.Directory, userId)
+	if err != nil {
+		return err
+	}
+
+	for i, keep := range retainedBackups(backups, schedule) {
+		if keep {
+			continue
+		}
+		fullPath := filepath.Join(schedule.Directory, fmt.Sprintf("%d", userId), backups[i].Name)
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove backup: %w", err)
+		}
+	}
+	return nil
+}
+
+// retainedBackups tells, for backups sorted most recent first, which ones a retention rule keeps:
+// the KeepLast most recent ones, then the most recent of each of the last KeepDaily days
+// and KeepWeekly ISO weeks having backups. The most recent backup is always kept, whatever the rule.
+func retainedBackups(backups []*models.StoredBackup, schedule BackupSchedule) []bool {
+	kept := make([]bool, len(backups))
+	for i := 0; i < len(backups) && i < max(schedule.KeepLast, 1); i++ {
+		kept[i] = true
+	}
+
+	keepPeriods := func(count int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for i, backup := range backups {
+			key := period(time.UnixMilli(backup.CreatedTimestamp).UTC())
+			if seen[key] {
+				continue
+			}
+			if len(seen) == count {
+				return
+			}
+			seen[key] = true
+			kept[i] = true
+		}
+	}
+	keepPeriods(schedule.KeepDaily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepPeriods(schedule.KeepWeekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	return kept
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"slices"
+	"structured-notes/models"
+	"testing"
+	"time"
+)
+
+func TestRetainedBackups(t *testing.T) {
+	now := time.Date(2025, 3, 12, 12, 0, 0, 0, time.UTC)
+	// most recent first: two today, one yesterday, one 8 days ago
+	backups := make([]*models.StoredBackup, 0)
+	for _, age := range []time.Duration{0, 2 * time.Hour, 24 * time.Hour, 8 * 24 * time.Hour} {
+		backups = append(backups, &models.StoredBackup{CreatedTimestamp: now.Add(-age).UnixMilli()})
+	}
+
+	tests := []struct {
+		name     string
+		schedule BackupSchedule
+		want     []bool
+	}{
+		{"nothing to keep keeps the last one", BackupSchedule{}, []bool{true, false, false, false}},
+		{"last", BackupSchedule{KeepLast: 2}, []bool{true, true, false, false}},
+		{"daily", BackupSchedule{KeepDaily: 2}, []bool{true, false, true, false}},
+		{"weekly", BackupSchedule{KeepWeekly: 2}, []bool{true, false, false, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := retainedBackups(backups, test.schedule); !slices.Equal(got, test.want) {
+				t.Errorf("retainedBackups = %v, want %v", got, test.want)
+			}
+		})
+	}
+	if got := retainedBackups(nil, BackupSchedule{}); len(got) != 0 {
+		t.Errorf("retainedBackups of no backups = %v", got)
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/storage"
+	"structured-notes/types"
+	"structured-notes/utils"
+)
+
+var ErrBatchFailed = errors.New("an operation failed, the batch was rolled back")
+
+type BatchService interface {
+	RunBatch(request *models.BatchRequest, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, retention VersionRetention) ([]*models.BatchResult, error)
+}
+
+type batchService struct {
+	repos     *repositories.RepositoryManager
+	storage   storage.Storage
+	snowflake *utils.Snowflake
+}
+
+func NewBatchService(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) BatchService {
+	return &batchService{
+		repos:     repos,
+		storage:   store,
+		snowflake: snowflake,
+	}
+}
+
+// RunBatch runs node operations in a single transaction, each one authorized like its own endpoint.
+// In atomic mode the first failure rolls back everything and ErrBatchFailed is returned along with the results,
+// in best effort mode failed operations are rolled back on their own and the others are committed.
+func (s *batchService) RunBatch(request *models.BatchRequest, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, retention VersionRetention) ([]*models.BatchResult, error) {
+	atomic := request.Mode != models.BatchModeBestEffort
+
+	results := make([]*models.BatchResult, len(request.Operations))
+	for i, op := range request.Operations {
+		results[i] = &models.BatchResult{Index: i, Op: op.Op, Status: models.BatchStatusSkipped}
+	}
+
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		nodes := newNodeService(repos, s.storage, s.snowflake)
+		// permissions are checked against the state of the transaction
+		authorizer := permissions.NewAuthorizer(repos.Permission)
+
+		for i, op := range request.Operations {
+			var node *models.Node
+			run := func() error {
+				var err error
+				node, err = nodes.runBatchOperation(op, connectedUserId, connectedUserRole, authorizer, retention)
+				return err
+			}
+
+			var err error
+			if atomic {
+				err = run()
+			} else {
+				err = repos.Savepoint(fmt.Sprintf("batch_op_%d", i), run)
+			}
+
+			if err != nil {
+				results[i].Status = models.BatchStatusError
+				results[i].Error = err.Error()
+				if atomic {
+					return ErrBatchFailed
+				}
+				continue
+			}
+			results[i].Status = models.BatchStatusOk
+			results[i].Node = node
+		}
+		return nil
+	})
+
+	if errors.Is(err, ErrBatchFailed) {
+		for _, result := range results {
+			if result.Status == models.BatchStatusOk {
+				result.Status = models.BatchStatusRolledBack
+				result.Node = nil
+			}
+		}
+		return results, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (s *nodeService) runBatchOperation(op *models.BatchOperation, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	if op.Op != "create" && op.Id == nil {
+		return nil, errors.New("id is required")
+	}
+
+	switch op.Op {
+	case "create":
+		if op.Node == nil {
+			return nil, errors.New("node is required")
+		}
+		if op.Node.ParentId != nil {
+			if _, err := s.checkParent(op.Node.Role, *op.Node.ParentId, connectedUserId, connectedUserRole, authorizer); err != nil {
+				return nil, err
+			}
+		}
+		return s.CreateNode(op.Node, connectedUserId)
+	case "update":
+		if op.Node == nil {
+			return nil, errors.New("node is required")
+		}
+		return s.UpdateNode(*op.Id, op.Node, nil, connectedUserId, connectedUserRole, authorizer, retention)
+	case "patch":
+		if op.Patch == nil {
+			return nil, errors.New("patch is required")
+		}
+		return s.PatchNode(*op.Id, op.Patch, nil, connectedUserId, connectedUserRole, authorizer, retention)
+	case "move":
+		return s.MoveNode(*op.Id, op.ParentId, op.Order, connectedUserId, connectedUserRole, authorizer)
+	case "delete":
+		return nil, s.DeleteNode(*op.Id, connectedUserId, connectedUserRole, authorizer)
+	default:
+		return nil, fmt.Errorf("unknown operation %s", op.Op)
+	}
+}
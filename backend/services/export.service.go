@@ -0,0 +1,415 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"structured-notes/logger"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/storage"
+	"structured-notes/types"
+	"structured-notes/utils"
+	"time"
+)
+
+const (
+	ExportFormatMarkdown = "markdown" // documents as .md, from their content
+	ExportFormatHTML     = "html"     // documents as standalone pages, from their sanitized content_compiled
+	ExportFormatJSON     = "json"     // every node as .json, contents left as stored
+)
+
+var ErrUnsupportedExportFormat = errors.New("format must be markdown, html or json")
+
+// exportMediaFolder holds, at the root of an export, the media linked from outside the exported subtree
+const exportMediaFolder = "media"
+
+type ExportService interface {
+	// GetExport loads a node and its descendants for export, see NodeExport.WriteZip
+	GetExport(nodeId types.Snowflake, format string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*NodeExport, error)
+}
+
+type exportService struct {
+	nodeRepo repositories.NodeRepository
+	storage  storage.Storage
+}
+
+func NewExportService(nodeRepo repositories.NodeRepository, store storage.Storage) ExportService {
+	return &exportService{
+		nodeRepo: nodeRepo,
+		storage:  store,
+	}
+}
+
+var (
+	// [[id]] or [[id|label]]
+	exportWikiLinkPattern = regexp.MustCompile(`\[\[\s*(\d+)\s*(?:\|([^\]]*))?\]\]`)
+	// /nodes/:userId/:id, as used by the API and the dashboard
+	exportNodeURLPattern = regexp.MustCompile(`(?:https?://[^/\s)"'\]]+)?/nodes/[^/\s)"'\]]+/(\d+)`)
+	// /media/:userId/:nodeId.ext, as embedded by documents
+	exportMediaURLPattern = regexp.MustCompile(`(?:https?://[^/\s)"'\]]+)?/media/\d+/(\d+)(?:\.[A-Za-z0-9]+)?`)
+)
+
+// NodeExport is a subtree ready to be written as a ZIP archive
+type NodeExport struct {
+	Root   *models.Node
+	format string
+	nodes  []*models.Node // parents first, see NodeRepository.GetSubtree
+	media  []*models.Node // media linked from the documents, outside of the subtree
+
+	storage storage.Storage
+	files   map[types.Snowflake]string // node: file in the archive
+	folders map[types.Snowflake]string // node: folder of its children in the archive
+}
+
+func (s *exportService) GetExport(nodeId types.Snowflake, format string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*NodeExport, error) {
+	if format != ExportFormatMarkdown && format != ExportFormatHTML && format != ExportFormatJSON {
+		return nil, ErrUnsupportedExportFormat
+	}
+
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
+
+	// access granted on a node covers its descendants, see PermissionRepository.HasPermission
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	nodes, err := s.nodeRepo.GetSubtree(nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	export := &NodeExport{
+		Root:    dbNode,
+		format:  format,
+		nodes:   nodes,
+		media:   make([]*models.Node, 0),
+		files:   make(map[types.Snowflake]string, len(nodes)),
+		folders: make(map[types.Snowflake]string),
+		storage: s.storage,
+	}
+
+	if format != ExportFormatJSON {
+		if err := s.loadLinkedMedia(export, connectedUserId, connectedUserRole, authorizer); err != nil {
+			return nil, err
+		}
+	}
+	export.layout()
+	return export, nil
+}
+
+// loadLinkedMedia adds the readable media embedded by the exported documents, uploads have no parent
+// so they rarely are part of the subtree
+func (s *exportService) loadLinkedMedia(export *NodeExport, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
+	exported := make(map[types.Snowflake]bool, len(export.nodes))
+	for _, node := range export.nodes {
+		exported[node.Id] = true
+	}
+
+	for _, node := range export.nodes {
+		for _, match := range exportMediaURLPattern.FindAllStringSubmatch(export.documentContent(node), -1) {
+			id, err := strconv.ParseUint(match[1], 10, 64)
+			if err != nil || exported[types.Snowflake(id)] {
+				continue
+			}
+			exported[types.Snowflake(id)] = true
+
+			media, err := s.nodeRepo.GetByID(types.Snowflake(id))
+			if err != nil {
+				return err
+			}
+			if media == nil || media.Role != models.NodeRoleMedia {
+				continue
+			}
+			if allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, media, permissions.ActionRead); !allowed || err != nil {
+				continue
+			}
+			export.media = append(export.media, media)
+		}
+	}
+	return nil
+}
+
+// layout gives every node its path in the archive. Nodes with children get a folder named after them,
+// documents also get a file next to it, names are made unique among siblings.
+func (e *NodeExport) layout() {
+	hasChildren := make(map[types.Snowflake]bool)
+	for _, node := range e.nodes {
+		if node.ParentId != nil {
+			hasChildren[*node.ParentId] = true
+		}
+	}
+
+	taken := make(map[string]bool)
+	unique := func(dir, name, ext string) string {
+		for i := 1; ; i++ {
+			candidate := name
+			if i > 1 {
+				candidate = fmt.Sprintf("%s (%d)", name, i)
+			}
+			full := path.Join(dir, candidate)
+			if !taken[strings.ToLower(full+ext)] && !taken[strings.ToLower(full)] {
+				taken[strings.ToLower(full+ext)] = true
+				return full
+			}
+		}
+	}
+
+	for _, node := range e.nodes {
+		dir := ""
+		if node.Id != e.Root.Id {
+			dir = e.folders[*node.ParentId]
+		}
+
+		if node.Role == models.NodeRoleMedia {
+			name, ext := exportMediaName(node)
+			e.files[node.Id] = unique(dir, name, ext) + ext
+			continue
+		}
+
+		name := unique(dir, exportFileName(node.Name), e.extension())
+		if hasChildren[node.Id] || isFolderRole(node.Role) {
+			e.folders[node.Id] = name
+			taken[strings.ToLower(name)] = true
+		}
+		if e.format == ExportFormatJSON || !isFolderRole(node.Role) {
+			e.files[node.Id] = name + e.extension()
+		}
+	}
+
+	for _, media := range e.media {
+		name, ext := exportMediaName(media)
+		e.files[media.Id] = unique(path.Join(e.folders[e.Root.Id], exportMediaFolder), name, ext) + ext
+	}
+}
+
+// WriteZip streams the archive to w
+func (e *NodeExport) WriteZip(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	for _, node := range e.nodes {
+		if folder, ok := e.folders[node.Id]; ok {
+			if _, err := archive.CreateHeader(&zip.FileHeader{Name: folder + "/", Modified: exportTime(node)}); err != nil {
+				return fmt.Errorf("failed to write folder: %w", err)
+			}
+		}
+		if err := e.writeNode(archive, node); err != nil {
+			return err
+		}
+	}
+	for _, media := range e.media {
+		if err := e.writeNode(archive, media); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func (e *NodeExport) writeNode(archive *zip.Writer, node *models.Node) error {
+	name, ok := e.files[node.Id]
+	if !ok {
+		return nil
+	}
+
+	if node.Role == models.NodeRoleMedia {
+		return e.writeMediaFile(archive, name, node)
+	}
+
+	var content []byte
+	switch e.format {
+	case ExportFormatJSON:
+		encoded, err := json.MarshalIndent(node, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = encoded
+	case ExportFormatHTML:
+		content = []byte(e.htmlPage(node, path.Dir(name)))
+	default:
+		content = []byte(e.rewriteLinks(e.documentContent(node), path.Dir(name)))
+	}
+
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: exportTime(node)})
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	_, err = file.Write(content)
+	return err
+}
+
+// writeMediaFile copies a media file in the archive, files missing from the storage are left out
+func (e *NodeExport) writeMediaFile(archive *zip.Writer, name string, node *models.Node) error {
+	filename, ok := mediaFileName(node)
+	if !ok {
+		return nil
+	}
+	src, _, err := e.storage.Get(filename)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Media file %s not exported: %v", filename, err))
+		return nil
+	}
+	defer src.Close()
+
+	// media are compressed already
+	file, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: exportTime(node)})
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		return fmt.Errorf("failed to copy media file: %w", err)
+	}
+	return nil
+}
+
+// documentContent returns what a node is exported from in the current format
+func (e *NodeExport) documentContent(node *models.Node) string {
+	content := node.Content
+	if e.format == ExportFormatHTML {
+		content = node.ContentCompiled
+	}
+	if content == nil {
+		return ""
+	}
+	return *content
+}
+
+func (e *NodeExport) htmlPage(node *models.Node, dir string) string {
+	content := e.documentContent(node)
+	body := e.rewriteLinks(utils.EscapeHTML(&content), dir)
+	return fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n%s\n</body>\n</html>\n",
+		html.EscapeString(node.Name), body)
+}
+
+// rewriteLinks points the links to exported nodes and media of a file in dir to their relative path
+func (e *NodeExport) rewriteLinks(content string, dir string) string {
+	relative := func(id string) (string, bool) {
+		nodeId, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return "", false
+		}
+		name, ok := e.files[types.Snowflake(nodeId)]
+		if !ok {
+			// categories only have a folder
+			if name, ok = e.folders[types.Snowflake(nodeId)]; !ok {
+				return "", false
+			}
+		}
+		return relativeExportPath(dir, name), true
+	}
+
+	content = exportWikiLinkPattern.ReplaceAllStringFunc(content, func(match string) string {
+		parts := exportWikiLinkPattern.FindStringSubmatch(match)
+		link, ok := relative(parts[1])
+		if !ok {
+			return match
+		}
+		label := strings.TrimSpace(parts[2])
+		if label == "" {
+			label = strings.TrimSuffix(path.Base(link), path.Ext(link))
+			if unescaped, err := url.PathUnescape(label); err == nil {
+				label = unescaped
+			}
+		}
+		if e.format == ExportFormatHTML {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, link, html.EscapeString(label))
+		}
+		return fmt.Sprintf("[%s](%s)", label, link)
+	})
+
+	for _, pattern := range []*regexp.Regexp{exportNodeURLPattern, exportMediaURLPattern} {
+		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
+			if link, ok := relative(pattern.FindStringSubmatch(match)[1]); ok {
+				return link
+			}
+			return match
+		})
+	}
+	return content
+}
+
+func (e *NodeExport) extension() string {
+	switch e.format {
+	case ExportFormatHTML:
+		return ".html"
+	case ExportFormatJSON:
+		return ".json"
+	default:
+		return ".md"
+	}
+}
+
+// relativeExportPath returns the escaped path of target as seen from dir, both relative to the archive root
+func relativeExportPath(dir, target string) string {
+	from := make([]string, 0)
+	if dir != "." && dir != "" {
+		from = strings.Split(dir, "/")
+	}
+	to := strings.Split(target, "/")
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+
+	parts := make([]string, 0)
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	for _, part := range to[common:] {
+		parts = append(parts, url.PathEscape(part))
+	}
+	return strings.Join(parts, "/")
+}
+
+func isFolderRole(role int) bool {
+	return role == models.NodeRoleWorkspace || role == models.NodeRoleCategory
+}
+
+// exportMediaName returns the name of a media file in an export, from its original file name
+func exportMediaName(node *models.Node) (string, string) {
+	ext := ""
+	if filename, ok := mediaFileName(node); ok {
+		ext = filepath.Ext(filename)
+	}
+	name := node.Name
+	if original, ok := node.Metadata.GetString("original_path"); ok && original != "" {
+		name = filepath.Base(original)
+	}
+	return exportFileName(strings.TrimSuffix(name, filepath.Ext(name))), ext
+}
+
+// exportFileName makes a node name usable as a file name on every platform
+func exportFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
+
+func exportTime(node *models.Node) time.Time {
+	return time.UnixMilli(node.UpdatedTimestamp)
+}
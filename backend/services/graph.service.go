@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/types"
+)
+
+const (
+	DefaultGraphDepth = 3
+	MaxGraphDepth     = 10
+)
+
+type GraphService interface {
+	GetGraph(nodeId types.Snowflake, depth int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Graph, error)
+}
+
+type graphService struct {
+	graphRepo repositories.GraphRepository
+	nodeRepo  repositories.NodeRepository
+}
+
+func NewGraphService(graphRepo repositories.GraphRepository, nodeRepo repositories.NodeRepository) GraphService {
+	return &graphService{
+		graphRepo: graphRepo,
+		nodeRepo:  nodeRepo,
+	}
+}
+
+// GetGraph returns the nodes below nodeId down to depth levels and the edges between them,
+// the connected user must be able to read nodeId
+func (s *graphService) GetGraph(nodeId types.Snowflake, depth int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Graph, error) {
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	depth = min(max(depth, 0), MaxGraphDepth)
+	graph, err := s.graphRepo.GetGraph(nodeId, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	// read access is inherited down the tree, see PermissionRepository.HasPermission: the whole subtree is readable
+	// once its root is, and the reference and tag edges of GraphRepository never leave it
+	return graph, nil
+}
@@ -0,0 +1,403 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/storage"
+	"structured-notes/types"
+	"structured-notes/utils"
+)
+
+var ErrUnsupportedImport = errors.New("only .md files and .zip archives can be imported")
+
+// maxImportEntries bounds the entries of an imported archive, folders included
+const maxImportEntries = 10000
+
+type ImportService interface {
+	Import(filename string, file io.ReaderAt, size int64, parentId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, limits MediaLimits) (*models.ImportReport, error)
+}
+
+type importService struct {
+	nodes *nodeService
+	media MediaService
+}
+
+func NewImportService(repos *repositories.RepositoryManager, store storage.Storage, media MediaService, snowflake *utils.Snowflake) ImportService {
+	return &importService{
+		nodes: newNodeService(repos, store, snowflake),
+		media: media,
+	}
+}
+
+var (
+	// ![alt](path "title"), the path may be wrapped in <> when it holds spaces
+	markdownImagePattern = regexp.MustCompile(`(!\[[^\]]*\]\()(<[^>]*>|[^)\s]+)`)
+	htmlImagePattern     = regexp.MustCompile(`(<img\s[^>]*?src=["'])([^"']+)`)
+)
+
+// Import creates nodes under parentId from a markdown file or a ZIP archive.
+// Folders become categories and markdown files documents, images linked from them with a relative path
+// are uploaded as media nodes and the links rewritten. Every file is reported on its own,
+// a failed file does not stop the others.
+// Files are read one at a time when needed, the bytes inflated from an archive are bounded by the remaining quota of the user.
+func (s *importService) Import(filename string, file io.ReaderAt, size int64, parentId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, limits MediaLimits) (*models.ImportReport, error) {
+	usedSize, err := s.nodes.nodeRepo.GetUserUploadsSize(connectedUserId)
+	if err != nil {
+		return nil, err
+	}
+	remaining := int64(limits.MaxUploadsSize) - usedSize
+	if size > remaining {
+		return nil, errors.New("total size of uploads exceeds the limit")
+	}
+
+	parent, err := s.nodes.checkParent(models.NodeRoleDocument, parentId, connectedUserId, connectedUserRole, authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &importRun{
+		service:   s,
+		userId:    connectedUserId,
+		parent:    parent,
+		limits:    limits,
+		files:     make(map[string]*zip.File),
+		folders:   make(map[string]*models.Node),
+		media:     make(map[string]string),
+		order:     make(map[types.Snowflake]int),
+		results:   make(map[string]*models.ImportFileResult),
+		report:    &models.ImportReport{ParentId: parentId, Files: make([]*models.ImportFileResult, 0)},
+		maxBytes:  int64(limits.MaxSize),
+		remaining: remaining,
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown":
+		name := path.Base(filepath.ToSlash(filename))
+		imp.files[name] = nil
+		imp.single = io.NewSectionReader(file, 0, size)
+	case ".zip":
+		if err := imp.readArchive(file, size); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedImport
+	}
+
+	imp.run()
+	return imp.report, nil
+}
+
+// importRun holds the state of a single import
+type importRun struct {
+	service   *importService
+	userId    types.Snowflake
+	parent    *models.Node
+	limits    MediaLimits
+	maxBytes  int64 // per file, once inflated
+	remaining int64 // bytes left to read from the archive, the remaining quota of the user
+
+	single  *io.SectionReader                   // the imported markdown file, when it is not an archive
+	files   map[string]*zip.File                // archive path: entry, read when used
+	folders map[string]*models.Node             // folder path: category, nil when it failed
+	media   map[string]string                   // archive path: media URL
+	order   map[types.Snowflake]int             // next order under a parent
+	results map[string]*models.ImportFileResult // archive path: result
+	report  *models.ImportReport
+}
+
+func (imp *importRun) readArchive(file io.ReaderAt, size int64) error {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return fmt.Errorf("invalid zip archive: %w", err)
+	}
+	if len(archive.File) > maxImportEntries {
+		return fmt.Errorf("archive holds more than %d entries", maxImportEntries)
+	}
+
+	for _, file := range archive.File {
+		name, ok := cleanArchivePath(file.Name)
+		if !ok {
+			continue
+		}
+		if file.FileInfo().IsDir() {
+			imp.addFolder(name)
+			continue
+		}
+		if dir := path.Dir(name); dir != "." {
+			imp.addFolder(dir)
+		}
+		if file.UncompressedSize64 > uint64(imp.maxBytes) {
+			imp.fail(name, errors.New("file size exceeds the limit"))
+			continue
+		}
+		imp.files[name] = file
+	}
+	return nil
+}
+
+// readFile reads a file of the import. No file may grow past the media size limit once inflated,
+// and all of them together past the remaining quota: entries of an archive may overlap or lie about their size.
+func (imp *importRun) readFile(name string) ([]byte, error) {
+	var reader io.Reader
+	if file := imp.files[name]; file != nil {
+		entry, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer entry.Close()
+		reader = entry
+	} else {
+		reader = imp.single
+	}
+
+	limit := min(imp.maxBytes, imp.remaining)
+	data, err := io.ReadAll(io.LimitReader(reader, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > imp.maxBytes {
+		return nil, errors.New("file size exceeds the limit")
+	}
+	if int64(len(data)) > imp.remaining {
+		imp.remaining = 0
+		return nil, errors.New("total size of uploads exceeds the limit")
+	}
+	imp.remaining -= int64(len(data))
+	return data, nil
+}
+
+// addFolder registers a folder along with its ancestors
+func (imp *importRun) addFolder(dir string) {
+	for dir != "." {
+		if _, ok := imp.folders[dir]; ok {
+			return
+		}
+		imp.folders[dir] = nil
+		dir = path.Dir(dir)
+	}
+}
+
+func (imp *importRun) run() {
+	// parents sort before their children
+	folders := make([]string, 0, len(imp.folders))
+	for dir := range imp.folders {
+		folders = append(folders, dir)
+	}
+	sort.Strings(folders)
+	for _, dir := range folders {
+		imp.createFolder(dir)
+	}
+
+	names := make([]string, 0, len(imp.files))
+	for name := range imp.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if isMarkdownFile(name) {
+			imp.createDocument(name)
+		}
+	}
+	for _, name := range names {
+		if _, done := imp.results[name]; !done {
+			imp.skip(name)
+		}
+	}
+
+	sort.Slice(imp.report.Files, func(i, j int) bool {
+		return imp.report.Files[i].Path < imp.report.Files[j].Path
+	})
+}
+
+func (imp *importRun) createFolder(dir string) {
+	parent, err := imp.parentOf(path.Dir(dir))
+	if err != nil {
+		imp.fail(dir+"/", err)
+		return
+	}
+	if !canNestUnder(models.NodeRoleCategory, parent.Role) {
+		imp.fail(dir+"/", fmt.Errorf("a folder cannot be imported under a node with role %d", parent.Role))
+		return
+	}
+
+	node, err := imp.createNode(parent, &models.Node{
+		Name: nodeName(path.Base(dir)),
+		Role: models.NodeRoleCategory,
+	})
+	if err != nil {
+		imp.fail(dir+"/", err)
+		return
+	}
+	imp.folders[dir] = node
+	imp.succeed(dir+"/", node)
+}
+
+func (imp *importRun) createDocument(name string) {
+	parent, err := imp.parentOf(path.Dir(name))
+	if err != nil {
+		imp.fail(name, err)
+		return
+	}
+
+	data, err := imp.readFile(name)
+	if err != nil {
+		imp.fail(name, err)
+		return
+	}
+	content := imp.rewriteImages(name, string(data))
+	contentCompiled := utils.CompileMarkdown(content) // the frontend is not there to compile it, the HTML export renders it
+	node, err := imp.createNode(parent, &models.Node{
+		Name:            nodeName(strings.TrimSuffix(path.Base(name), path.Ext(name))),
+		Role:            models.NodeRoleDocument,
+		Content:         &content,
+		ContentCompiled: &contentCompiled,
+	})
+	if err != nil {
+		imp.fail(name, err)
+		return
+	}
+	imp.succeed(name, node)
+}
+
+// parentOf returns the node a file of dir goes under
+func (imp *importRun) parentOf(dir string) (*models.Node, error) {
+	if dir == "." {
+		return imp.parent, nil
+	}
+	folder := imp.folders[dir]
+	if folder == nil {
+		return nil, errors.New("parent folder was not imported")
+	}
+	return folder, nil
+}
+
+func (imp *importRun) createNode(parent *models.Node, node *models.Node) (*models.Node, error) {
+	order := imp.order[parent.Id]
+	imp.order[parent.Id] = order + 1
+
+	node.ParentId = &parent.Id
+	node.Accessibility = utils.IntPtr(1)
+	node.Order = &order
+	return imp.service.nodes.CreateNode(node, imp.userId)
+}
+
+// rewriteImages replaces relative image links of the document at name with links to uploaded media nodes.
+// Images that cannot be uploaded are reported and their links left untouched.
+func (imp *importRun) rewriteImages(name string, content string) string {
+	replace := func(pattern *regexp.Regexp) {
+		content = pattern.ReplaceAllStringFunc(content, func(match string) string {
+			parts := pattern.FindStringSubmatch(match)
+			link := strings.TrimSuffix(strings.TrimPrefix(parts[2], "<"), ">")
+
+			target, ok := resolveImageLink(path.Dir(name), link)
+			if !ok {
+				return match
+			}
+			mediaURL, ok := imp.uploadImage(target)
+			if !ok {
+				return match
+			}
+			return parts[1] + mediaURL
+		})
+	}
+	replace(markdownImagePattern)
+	replace(htmlImagePattern)
+	return content
+}
+
+// uploadImage uploads an image of the archive once, later links reuse the media node
+func (imp *importRun) uploadImage(name string) (string, bool) {
+	if mediaURL, ok := imp.media[name]; ok {
+		return mediaURL, mediaURL != ""
+	}
+	if _, ok := imp.files[name]; !ok {
+		return "", false
+	}
+	imp.media[name] = ""
+
+	data, err := imp.readFile(name)
+	if err != nil {
+		imp.fail(name, err)
+		return "", false
+	}
+	node, err := imp.service.media.UploadFile(path.Base(name), data, imp.userId, imp.limits)
+	if err != nil {
+		imp.fail(name, err)
+		return "", false
+	}
+	imp.succeed(name, node)
+
+	// documents embed media as /media/[userId]/[nodeId].ext
+	filename, _ := mediaFileName(node)
+	imp.media[name] = "/media/" + filename
+	return imp.media[name], true
+}
+
+func (imp *importRun) succeed(name string, node *models.Node) {
+	imp.results[name] = &models.ImportFileResult{Path: name, Status: models.ImportStatusOk, NodeId: &node.Id}
+	imp.report.Files = append(imp.report.Files, imp.results[name])
+	imp.report.Imported++
+}
+
+func (imp *importRun) fail(name string, err error) {
+	imp.results[name] = &models.ImportFileResult{Path: name, Status: models.ImportStatusError, Error: err.Error()}
+	imp.report.Files = append(imp.report.Files, imp.results[name])
+	imp.report.Failed++
+}
+
+func (imp *importRun) skip(name string) {
+	imp.results[name] = &models.ImportFileResult{Path: name, Status: models.ImportStatusSkipped}
+	imp.report.Files = append(imp.report.Files, imp.results[name])
+}
+
+// cleanArchivePath normalizes the path of an archive entry.
+// Entries escaping the archive, hidden files and macOS metadata are left out.
+func cleanArchivePath(name string) (string, bool) {
+	name = path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "/"))
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", false
+	}
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// resolveImageLink returns the archive path an image link of a document in dir points to.
+// Absolute URLs, rooted paths and data URIs are not relative links.
+func resolveImageLink(dir, link string) (string, bool) {
+	if link == "" || strings.HasPrefix(link, "/") || strings.HasPrefix(link, "#") || strings.Contains(link, ":") {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(link); err == nil {
+		link = unescaped
+	}
+	link, _, _ = strings.Cut(link, "?")
+	return cleanArchivePath(path.Join(dir, link))
+}
+
+func isMarkdownFile(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// nodeName truncates a file name to the 50 characters allowed for node names
+func nodeName(name string) string {
+	if runes := []rune(name); len(runes) > 50 {
+		return string(runes[:50])
+	}
+	return name
+}
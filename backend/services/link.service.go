@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/types"
+	"structured-notes/utils"
+)
+
+type LinkService interface {
+	GetBacklinks(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeLink, error)
+	GetOutlinks(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeLink, error)
+}
+
+type linkService struct {
+	linkRepo repositories.LinkRepository
+	nodeRepo repositories.NodeRepository
+}
+
+func NewLinkService(linkRepo repositories.LinkRepository, nodeRepo repositories.NodeRepository) LinkService {
+	return &linkService{
+		linkRepo: linkRepo,
+		nodeRepo: nodeRepo,
+	}
+}
+
+// GetBacklinks lists the readable nodes linking to nodeId
+func (s *linkService) GetBacklinks(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeLink, error) {
+	if err := s.checkReadable(nodeId, connectedUserId, connectedUserRole, authorizer); err != nil {
+		return nil, err
+	}
+
+	links, err := s.linkRepo.GetByTarget(nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	backlinks := make([]*models.NodeLink, 0, len(links))
+	for _, link := range links {
+		source, err := s.nodeRepo.GetByID(link.SourceId)
+		if err != nil {
+			return nil, err
+		}
+		if source == nil || !s.canRead(source, connectedUserId, connectedUserRole, authorizer) {
+			continue
+		}
+		link.Node = withoutContent(source)
+		backlinks = append(backlinks, link)
+	}
+	return backlinks, nil
+}
+
+// GetOutlinks lists the nodes nodeId links to. Links to deleted nodes and to nodes the connected user cannot read
+// are kept and flagged as broken alike, so that the listing does not reveal which private nodes exist.
+func (s *linkService) GetOutlinks(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeLink, error) {
+	if err := s.checkReadable(nodeId, connectedUserId, connectedUserRole, authorizer); err != nil {
+		return nil, err
+	}
+
+	links, err := s.linkRepo.GetBySource(nodeId)
+	if err != nil {
+		return nil, err
+	}
+
+	outlinks := make([]*models.NodeLink, 0, len(links))
+	for _, link := range links {
+		target, err := s.nodeRepo.GetByID(link.TargetId)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil || !s.canRead(target, connectedUserId, connectedUserRole, authorizer) {
+			link.Broken = true
+			outlinks = append(outlinks, link)
+			continue
+		}
+		link.Node = withoutContent(target)
+		outlinks = append(outlinks, link)
+	}
+	return outlinks, nil
+}
+
+func (s *linkService) checkReadable(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return err
+	}
+	if dbNode == nil {
+		return errors.New("node not found")
+	}
+
+	if !s.canRead(dbNode, connectedUserId, connectedUserRole, authorizer) {
+		return errors.New("unauthorized")
+	}
+	return nil
+}
+
+func (s *linkService) canRead(dbNode *models.Node, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) bool {
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
+	return allowed && err == nil
+}
+
+// withoutContent keeps link listings light, the content is loaded with the node itself
+func withoutContent(node *models.Node) *models.Node {
+	node.Content = nil
+	node.ContentCompiled = nil
+	return node
+}
+
+// saveNodeLinks stores the nodes referenced in the content of a node, see utils.ParseNodeLinks
+func saveNodeLinks(linkRepo repositories.LinkRepository, node *models.Node) error {
+	targetIds := make([]types.Snowflake, 0)
+	for _, targetId := range utils.ParseNodeLinks(utils.StringValue(node.Content)) {
+		if targetId != node.Id {
+			targetIds = append(targetIds, targetId)
+		}
+	}
+	return linkRepo.SetLinks(node.Id, targetIds, node.UpdatedTimestamp)
+}
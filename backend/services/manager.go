@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"structured-notes/logger"
 	"structured-notes/repositories"
+	"structured-notes/storage"
 	"structured-notes/utils"
 )
 
@@ -15,13 +16,23 @@ type ServiceManager struct {
 	Log         LogService
 	Session     SessionService
 	Media       MediaService
+	Version     VersionService
+	Search      SearchService
+	Tag         TagService
+	Link        LinkService
+	Graph       GraphService
+	Batch       BatchService
+	Import      ImportService
+	Export      ExportService
+	Backup      BackupService
+	Upload      UploadService
 	initialized bool
 }
 
-func NewServiceManager(repos *repositories.RepositoryManager, snowflake *utils.Snowflake) (*ServiceManager, error) {
+func NewServiceManager(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) (*ServiceManager, error) {
 	sm := &ServiceManager{}
 
-	if err := sm.initializeServices(repos, snowflake); err != nil {
+	if err := sm.initializeServices(repos, store, snowflake); err != nil {
 		return nil, fmt.Errorf("failed to initialize services: %w", err)
 	}
 
@@ -30,14 +41,24 @@ func NewServiceManager(repos *repositories.RepositoryManager, snowflake *utils.S
 	return sm, nil
 }
 
-func (sm *ServiceManager) initializeServices(repos *repositories.RepositoryManager, snowflake *utils.Snowflake) error {
+func (sm *ServiceManager) initializeServices(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) error {
 	sm.Auth = NewAuthService(repos.User, repos.Session, repos.Log, snowflake)
 	sm.User = NewUserService(repos.User, repos.Log, snowflake)
-	sm.Node = NewNodeService(repos.Node, repos.Permission, snowflake)
+	sm.Node = NewNodeService(repos, store, snowflake)
 	sm.Permission = NewPermissionService(repos.Permission, repos.Node, snowflake)
 	sm.Log = NewLogService(repos.Log, snowflake)
 	sm.Session = NewSessionService(repos.Session)
-	sm.Media = NewMediaService(repos.Node, snowflake)
+	sm.Media = NewMediaService(repos.Node, repos.User, store, snowflake)
+	sm.Version = NewVersionService(repos, snowflake)
+	sm.Search = NewSearchService(repos.Search)
+	sm.Tag = NewTagService(repos.Tag)
+	sm.Link = NewLinkService(repos.Link, repos.Node)
+	sm.Graph = NewGraphService(repos.Graph, repos.Node)
+	sm.Batch = NewBatchService(repos, store, snowflake)
+	sm.Import = NewImportService(repos, store, sm.Media, snowflake)
+	sm.Export = NewExportService(repos.Node, store)
+	sm.Backup = NewBackupService(repos, store, snowflake)
+	sm.Upload = NewUploadService(repos.Node, sm.Media, snowflake)
 
 	return nil
 }
@@ -1,92 +1,156 @@
 package services
 
 import (
-	"encoding/json"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"io"
+	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
+	"strings"
 	"structured-notes/logger"
 	"structured-notes/models"
 	"structured-notes/permissions"
 	"structured-notes/repositories"
+	"structured-notes/storage"
 	"structured-notes/types"
 	"structured-notes/utils"
+	"time"
 )
 
 type MediaService interface {
-	CreateBackup(userId types.Snowflake) (string, error)
-	UploadFile(filename string, fileSize int64, fileContent []byte, mimeType string, userId types.Snowflake, maxSize, maxUploadsSize float64, supportedTypes []string) (*models.Node, error)
-	UploadAvatar(filename string, fileSize int64, fileContent []byte, mimeType string, userId types.Snowflake, maxSize float64, supportedTypes []string) error
+	// UploadFile stores a file as a media node, its type is detected from its content and must match its extension
+	UploadFile(filename string, fileContent []byte, userId types.Snowflake, limits MediaLimits) (*models.Node, error)
+	// UploadFileAt is UploadFile for a file that is not loaded in memory, only images are read whole
+	UploadFileAt(filename string, file io.ReaderAt, fileSize int64, userId types.Snowflake, limits MediaLimits) (*models.Node, error)
+	UploadAvatar(filename string, fileContent []byte, userId types.Snowflake, maxSize float64, supportedTypes []string) (*models.User, error)
+	// GetAvatar opens the avatar file of a user closest to size, storage.ErrNotFound is returned when there is none
+	GetAvatar(userId types.Snowflake, size int) (*MediaFile, error)
 	DeleteUpload(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error
 	DeleteAllFromUser(userId types.Snowflake) error
-	GetMediaFile(nodeId types.Snowflake, userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error
+	// ReconcileMedia compares the stored files with the media nodes and users,
+	// orphan files are removed unless dryRun is set and media nodes without a file are reported
+	ReconcileMedia(dryRun bool) (*models.MediaReport, error)
+	// GetMediaFile opens the file of a media node, or its narrowest variant at least width pixels wide when width is set.
+	// storage.ErrNotFound is returned when the file is missing.
+	GetMediaFile(nodeId types.Snowflake, userId types.Snowflake, width int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*MediaFile, error)
+}
+
+// MediaFile is an opened media or avatar file, with what HTTP caching needs
+type MediaFile struct {
+	Content io.ReadCloser // an io.ReadSeeker as well when the storage can seek
+	Info    *storage.ObjectInfo
+	ETag    string // strong and quoted, from the hash of the content
+	Public  bool   // visible to anyone, shared caches may keep it
+}
+
+// memoryFile is a file read whole, small enough to be hashed on every request
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
 }
 
 type mediaService struct {
 	nodeRepo  repositories.NodeRepository
+	userRepo  repositories.UserRepository
+	storage   storage.Storage
 	snowflake *utils.Snowflake
 }
 
-func NewMediaService(nodeRepo repositories.NodeRepository, snowflake *utils.Snowflake) MediaService {
+func NewMediaService(nodeRepo repositories.NodeRepository, userRepo repositories.UserRepository, store storage.Storage, snowflake *utils.Snowflake) MediaService {
 	return &mediaService{
 		nodeRepo:  nodeRepo,
+		userRepo:  userRepo,
+		storage:   store,
 		snowflake: snowflake,
 	}
 }
 
-func (s *mediaService) CreateBackup(userId types.Snowflake) (string, error) {
-	nodes, err := s.nodeRepo.GetAllForBackup(userId)
-	if err != nil {
-		return "", err
-	}
+// MediaLimits are the upload limits of the [Media] configuration
+type MediaLimits struct {
+	MaxSize        float64            // bytes per file
+	MaxSizes       map[string]float64 // bytes per file of a type ("video/mp4") or family ("video"), MaxSize otherwise
+	MaxUploadsSize float64            // bytes per user
+	SupportedTypes []string
+}
 
-	backup := map[string]interface{}{
-		"nodes": nodes,
+// MaxSizeOf returns the size limit of a file of mimeType
+func (l MediaLimits) MaxSizeOf(mimeType string) int64 {
+	if maxSize, ok := l.MaxSizes[mimeType]; ok {
+		return int64(maxSize)
 	}
-	jsonString, err := json.Marshal(backup)
-	if err != nil {
-		return "", err
+	family, _, _ := strings.Cut(mimeType, "/")
+	if maxSize, ok := l.MaxSizes[family]; ok {
+		return int64(maxSize)
 	}
+	return int64(l.MaxSize)
+}
 
-	objectName := fmt.Sprintf("%d/backups/backup.json", userId)
+func (s *mediaService) UploadFile(filename string, fileContent []byte, userId types.Snowflake, limits MediaLimits) (*models.Node, error) {
+	return s.UploadFileAt(filename, bytes.NewReader(fileContent), int64(len(fileContent)), userId, limits)
+}
 
-	// NOT IMPLEMENTED
-	logger.Info("NOT IMPLEMENTED")
-	n := 100
-	if len(jsonString) < n {
-		n = len(jsonString)
+func (s *mediaService) UploadFileAt(filename string, file io.ReaderAt, fileSize int64, userId types.Snowflake, limits MediaLimits) (*models.Node, error) {
+	mimeType, err := utils.DetectMediaTypeAt(filename, file, fileSize)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(limits.SupportedTypes, mimeType) {
+		return nil, errors.New("file type not supported")
 	}
-	strToLog := string(jsonString[:n])
-	logger.Info("backup" + strToLog)
-
-	return objectName, nil
-}
 
-func (s *mediaService) UploadFile(filename string, fileSize int64, fileContent []byte, mimeType string, userId types.Snowflake, maxSize, maxUploadsSize float64, supportedTypes []string) (*models.Node, error) {
-	if fileSize > int64(maxSize) {
-		return nil, errors.New("file size exceeds the limit")
+	// images are decoded for their variants, other files are streamed to the storage
+	var fileContent []byte
+	if slices.Contains(imageVariantTypes, mimeType) {
+		fileContent = make([]byte, fileSize)
+		if _, err := file.ReadAt(fileContent, 0); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		// photos carry the position they were taken at
+		if mimeType == "image/jpeg" {
+			fileContent, err = utils.StripJPEGMetadata(fileContent)
+			if err != nil {
+				return nil, err
+			}
+		}
+		fileSize = int64(len(fileContent))
 	}
 
-	if !slices.Contains(supportedTypes, mimeType) {
-		return nil, errors.New("file type not supported")
+	if fileSize > limits.MaxSizeOf(mimeType) {
+		return nil, errors.New("file size exceeds the limit")
 	}
 
 	totalSize, err := s.nodeRepo.GetUserUploadsSize(userId)
 	if err != nil {
 		return nil, err
 	}
-	if totalSize+fileSize > int64(maxUploadsSize) {
+	if totalSize+fileSize > int64(limits.MaxUploadsSize) {
 		return nil, errors.New("total size of uploads exceeds the limit")
 	}
 
 	id := s.snowflake.Generate()
 	ext := filepath.Ext(filename)
 
-	mediaFileName := filepath.Join(fmt.Sprintf("%d", userId), fmt.Sprintf("%d%s", id, ext))
-
-	err = saveMediaFile(fileContent, mediaFileName)
+	mediaFileName := path.Join(fmt.Sprintf("%d", userId), fmt.Sprintf("%d%s", id, ext))
+
+	// the checksum makes the ETag of the media, see GetMediaFile
+	var checksum string
+	if fileContent != nil {
+		sum := sha256.Sum256(fileContent)
+		checksum = hex.EncodeToString(sum[:])
+		err = saveMediaFile(s.storage, fileContent, mediaFileName)
+	} else {
+		hash := sha256.New()
+		err = s.storage.Put(mediaFileName, io.TeeReader(io.NewSectionReader(file, 0, fileSize), hash), fileSize)
+		checksum = hex.EncodeToString(hash.Sum(nil))
+	}
 	if err != nil {
 		logger.Info("File " + mediaFileName + " not saved")
 		logger.Error(fmt.Sprintf("Error saving file: %v", err))
@@ -99,6 +163,7 @@ func (s *mediaService) UploadFile(filename string, fileSize int64, fileContent [
 		"filetype":         mimeType,
 		"original_path":    filename,
 		"transformed_path": transformedPath,
+		"sha256":           checksum,
 	}
 
 	name := filename
@@ -112,7 +177,7 @@ func (s *mediaService) UploadFile(filename string, fileSize int64, fileContent [
 		UserId:          userId,
 		ParentId:        nil,
 		Name:            name,
-		Role:            4,
+		Role:            models.NodeRoleMedia,
 		Accessibility:   accessibility,
 		Access:          0,
 		Size:            &fileSize,
@@ -121,46 +186,310 @@ func (s *mediaService) UploadFile(filename string, fileSize int64, fileContent [
 		Metadata:        &metadata,
 	}
 
+	// variants are a convenience, the upload goes on without them
+	variantFiles, err := saveImageVariants(s.storage, node, fileContent)
+	if err != nil {
+		logger.Warn(fmt.Sprintf("Variants of %s not generated: %v", mediaFileName, err))
+	}
+
 	if err := s.nodeRepo.Create(node); err != nil {
-		// TODO
-		// Here the saved file should also be removed
+		for _, filename := range append(variantFiles, mediaFileName) {
+			if err := removeMediaFile(s.storage, filename); err != nil {
+				logger.Error(fmt.Sprintf("Error removing media file %s: %v", filename, err))
+			}
+		}
 		return nil, err
 	}
 
 	return node, nil
 }
 
-func saveMediaFile(fileContent []byte, filename string) error {
-	// Build full path: /media/[id1]/[id2].ext
-	fullPath := filepath.Join("media", filename)
+// MediaVariantWidths are the widths images are scaled down to on upload, the smallest variant is the thumbnail of the media
+var MediaVariantWidths = []int{160, 320, 640, 1280}
+
+const mediaVariantQuality = 85
+
+// imageVariantTypes are the images variants are generated for, GIFs from their first frame.
+// Variants of WebP images are stored as PNG, like the other formats but JPEG.
+var imageVariantTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
 
-	// Ensure parent directories exist
-	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
-		return fmt.Errorf("failed to create directories: %w", err)
+// saveImageVariants stores the variants of an image media narrower than the original next to its file,
+// as [nodeId]-w[width].jpg for JPEG images and .png otherwise to keep transparency.
+// The metadata of the node gets the image size and the variant files, and the node the URL of its thumbnail.
+// The stored files are returned, none when the media is not a supported image.
+func saveImageVariants(store storage.Storage, node *models.Node, content []byte) ([]string, error) {
+	mimeType, _ := node.Metadata.GetString("filetype")
+	if !slices.Contains(imageVariantTypes, mimeType) {
+		return nil, nil
 	}
 
-	if err := os.WriteFile(fullPath, fileContent, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	img, format, err := utils.DecodeImage(content)
+	if err != nil {
+		return nil, err
+	}
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	saved := make([]string, 0, len(MediaVariantWidths))
+	variants := make(map[string]interface{}, len(MediaVariantWidths))
+	for _, variantWidth := range MediaVariantWidths {
+		if variantWidth >= width {
+			break
+		}
+		resized := utils.ResizeImage(img, variantWidth, max(1, height*variantWidth/width))
+
+		var encoded []byte
+		ext := ".png"
+		if format == "jpeg" {
+			ext = ".jpg"
+			encoded, err = utils.EncodeJPEG(resized, mediaVariantQuality)
+		} else {
+			encoded, err = utils.EncodePNG(resized)
+		}
+		if err == nil {
+			filename := mediaVariantKey(node, variantWidth, ext)
+			transformedPath := path.Base(filename)
+			if err = saveMediaFile(store, encoded, filename); err == nil {
+				saved = append(saved, filename)
+				variants[strconv.Itoa(variantWidth)] = transformedPath
+				continue
+			}
+		}
+
+		for _, filename := range saved {
+			removeMediaFile(store, filename)
+		}
+		return nil, err
 	}
 
-	return nil
+	(*node.Metadata)["width"] = width
+	(*node.Metadata)["height"] = height
+	(*node.Metadata)["variants"] = variants
+	node.Thumbnail = mediaThumbnail(node)
+	return saved, nil
 }
 
-func (s *mediaService) UploadAvatar(filename string, fileSize int64, fileContent []byte, mimeType string, userId types.Snowflake, maxSize float64, supportedTypes []string) error {
-	if fileSize > int64(maxSize) {
-		return errors.New("file size exceeds the limit")
+// copyMediaVariants copies the variant files of a media node for copied, whose file is already set.
+// The copied files are returned.
+func copyMediaVariants(store storage.Storage, node *models.Node, copied *models.Node) ([]string, error) {
+	variants := mediaVariants(node)
+	if len(variants) == 0 {
+		return nil, nil
 	}
 
+	copiedFiles := make([]string, 0, len(variants))
+	copiedVariants := make(map[string]interface{}, len(variants))
+	for width, filename := range variants {
+		newFilename := mediaVariantKey(copied, width, path.Ext(filename))
+		if err := copyMediaFile(store, filename, newFilename); err != nil {
+			return copiedFiles, err
+		}
+		copiedFiles = append(copiedFiles, newFilename)
+		copiedVariants[strconv.Itoa(width)] = path.Base(newFilename)
+	}
+
+	(*copied.Metadata)["variants"] = copiedVariants
+	copied.Thumbnail = mediaThumbnail(copied)
+	return copiedFiles, nil
+}
+
+// mediaVariants returns the storage keys of the variant files of a media node by width.
+// The metadata only tells which widths exist and their format, keys are built like mediaFileName.
+func mediaVariants(node *models.Node) map[int]string {
+	if node.Metadata == nil || node.Role != models.NodeRoleMedia {
+		return nil
+	}
+	stored, ok := (*node.Metadata)["variants"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	variants := make(map[int]string, len(stored))
+	for key, value := range stored {
+		width, err := strconv.Atoi(key)
+		transformedPath, ok := value.(string)
+		if err != nil || !ok || !slices.Contains(MediaVariantWidths, width) {
+			continue
+		}
+		if ext := path.Ext(transformedPath); ext == ".jpg" || ext == ".png" {
+			variants[width] = mediaVariantKey(node, width, ext)
+		}
+	}
+	return variants
+}
+
+// mediaVariantKey returns the storage key of a variant, [userId]/[nodeId]-w[width].jpg or .png
+func mediaVariantKey(node *models.Node, width int, ext string) string {
+	return fmt.Sprintf("%d/%d-w%d%s", node.UserId, node.Id, width, ext)
+}
+
+// mediaThumbnail returns the URL of the smallest variant of a media, nil when it has none
+func mediaThumbnail(node *models.Node) *string {
+	filename, ok := mediaFileName(node)
+	if !ok || len(mediaVariants(node)) == 0 {
+		return nil
+	}
+	thumbnail := fmt.Sprintf("/media/%s?w=%d", filename, MediaVariantWidths[0])
+	return &thumbnail
+}
+
+// mediaVariantFileName returns the storage key of the narrowest file of a media at least width pixels wide, and its width.
+// The original is the widest file, it is used when no variant is wide enough or width is 0, its width is then 0.
+func mediaVariantFileName(node *models.Node, width int) (string, int, bool) {
+	filename, ok := mediaFileName(node)
+	if !ok || width <= 0 {
+		return filename, 0, ok
+	}
+	best := 0
+	variants := mediaVariants(node)
+	for variantWidth := range variants {
+		if variantWidth >= width && (best == 0 || variantWidth < best) {
+			best = variantWidth
+		}
+	}
+	if best == 0 {
+		return filename, 0, true
+	}
+	return variants[best], best, true
+}
+
+func saveMediaFile(store storage.Storage, fileContent []byte, filename string) error {
+	// key: [id1]/[id2].ext
+	return store.Put(filename, bytes.NewReader(fileContent), int64(len(fileContent)))
+}
+
+func copyMediaFile(store storage.Storage, srcFilename, dstFilename string) error {
+	if _, err := store.Stat(dstFilename); err == nil {
+		return fmt.Errorf("file %s already exists", dstFilename)
+	}
+	return storage.Copy(store, srcFilename, dstFilename)
+}
+
+func removeMediaFile(store storage.Storage, filename string) error {
+	return store.Delete(filename)
+}
+
+// mediaFileName returns the storage key of a media node's file, [userId]/[nodeId].ext.
+// Metadata only provides the extension, which must be one of an accepted media type:
+// a key never points outside the folder of the owner or to another node.
+func mediaFileName(node *models.Node) (string, bool) {
+	if node.Role != models.NodeRoleMedia {
+		return "", false
+	}
+	transformedPath, ok := node.Metadata.GetString("transformed_path")
+	if !ok {
+		return "", false
+	}
+	ext := path.Ext(transformedPath)
+	if _, ok := utils.MediaTypeOf(ext); !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%d/%d%s", node.UserId, node.Id, ext), true
+}
+
+// AvatarSizes are the side lengths in pixels avatars are stored at, smallest first
+var AvatarSizes = []int{64, 128, 256}
+
+const avatarQuality = 90
+
+// avatarFileName returns the storage key of an avatar size, uploads overwrite the previous files
+func avatarFileName(userId types.Snowflake, size int) string {
+	return fmt.Sprintf("avatars/%d/%d.jpg", userId, size)
+}
+
+// UploadAvatar crops the image to a centered square and stores it at every avatar size.
+// Images are re-encoded as JPEG so no metadata of the upload is kept.
+// The avatar of the user is set to the public URL of the files, versioned so that clients drop cached copies.
+func (s *mediaService) UploadAvatar(filename string, fileContent []byte, userId types.Snowflake, maxSize float64, supportedTypes []string) (*models.User, error) {
+	if int64(len(fileContent)) > int64(maxSize) {
+		return nil, errors.New("file size exceeds the limit")
+	}
+
+	mimeType, err := utils.DetectMediaType(filename, fileContent)
+	if err != nil {
+		return nil, err
+	}
 	if !slices.Contains(supportedTypes, mimeType) {
-		return errors.New("file type not supported")
+		return nil, errors.New("file type not supported")
 	}
 
-	objectName := fmt.Sprintf("%d/avatar", userId)
-	// NOT IMPLEMENTED
-	logger.Info("NOT IMPLEMENTED")
-	logger.Info("filename" + objectName)
+	user, err := s.userRepo.GetByID(userId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
 
-	return nil
+	img, _, err := utils.DecodeImage(fileContent)
+	if err != nil {
+		return nil, err
+	}
+	square := utils.CropSquare(img)
+
+	files := make(map[string]bool, len(AvatarSizes))
+	for _, size := range AvatarSizes {
+		content, err := utils.EncodeJPEG(utils.ResizeImage(square, size, size), avatarQuality)
+		if err != nil {
+			return nil, err
+		}
+		key := avatarFileName(userId, size)
+		if err := saveMediaFile(s.storage, content, key); err != nil {
+			logger.Error(fmt.Sprintf("Error saving avatar: %v", err))
+			return nil, err
+		}
+		files[key] = true
+	}
+	logger.Info(fmt.Sprintf("Avatar of user %d saved from %s", userId, filename))
+
+	// files of sizes that are not served anymore
+	stored, err := s.storage.List(fmt.Sprintf("avatars/%d/", userId))
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error listing avatars: %v", err))
+	}
+	for _, object := range stored {
+		if !files[object.Key] {
+			if err := removeMediaFile(s.storage, object.Key); err != nil {
+				logger.Error(fmt.Sprintf("Error removing avatar %s: %v", object.Key, err))
+			}
+		}
+	}
+
+	now := time.Now()
+	avatar := fmt.Sprintf("/media/avatars/%d?v=%d", userId, now.Unix())
+	user.Avatar = &avatar
+	user.UpdatedTimestamp = now.UnixMilli()
+	return s.userRepo.Update(userId, user)
+}
+
+// GetAvatar opens the avatar file of a user, the smallest stored size at least as large as size.
+// A size of 0 or larger than every stored size gets the largest one.
+func (s *mediaService) GetAvatar(userId types.Snowflake, size int) (*MediaFile, error) {
+	served := AvatarSizes[len(AvatarSizes)-1]
+	if size > 0 {
+		for _, avatarSize := range AvatarSizes {
+			if avatarSize >= size {
+				served = avatarSize
+				break
+			}
+		}
+	}
+
+	file, info, err := s.storage.Get(avatarFileName(userId, served))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avatar: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	return &MediaFile{
+		Content: memoryFile{bytes.NewReader(content)},
+		Info:    info,
+		ETag:    fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])),
+		Public:  true,
+	}, nil
 }
 
 func (s *mediaService) DeleteUpload(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
@@ -171,40 +500,214 @@ func (s *mediaService) DeleteUpload(nodeId types.Snowflake, connectedUserId type
 	if node == nil {
 		return errors.New("node not found")
 	}
+	if node.Role != models.NodeRoleMedia {
+		return errors.New("node is not a media")
+	}
 
 	if allowed, err := authorizer.CanAccessUser(connectedUserId, node.UserId, connectedUserRole); !allowed || err != nil {
 		return errors.New("unauthorized")
 	}
 
-	prefix := fmt.Sprintf("%d/%d", node.UserId, node.Id)
-	// NOT IMPLEMENTED
-	logger.Info("NOT IMPLEMENTED")
-	logger.Info("filename prefix" + prefix)
+	if err := s.nodeRepo.Delete(nodeId); err != nil {
+		return err
+	}
 
-	return s.nodeRepo.Delete(nodeId)
+	// the node is gone already, a file left behind is collected by ReconcileMedia
+	for _, filename := range mediaNodeFiles(node) {
+		if err := removeMediaFile(s.storage, filename); err != nil {
+			logger.Error(fmt.Sprintf("Error removing media file %s: %v", filename, err))
+		}
+	}
+	return nil
 }
 
+// DeleteAllFromUser removes the media files and avatars of a user
 func (s *mediaService) DeleteAllFromUser(userId types.Snowflake) error {
-	prefix := fmt.Sprintf("%d/", userId)
-	// NOT IMPLEMENTED
-	logger.Info("NOT IMPLEMENTED")
-	logger.Info("filename prefix" + prefix)
+	var errs []error
+	for _, prefix := range []string{fmt.Sprintf("%d/", userId), fmt.Sprintf("avatars/%d/", userId)} {
+		objects, err := s.storage.List(prefix)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, object := range objects {
+			if err := removeMediaFile(s.storage, object.Key); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
 
-	return nil
+// mediaReconcileGrace leaves out recent files, uploads store their file before creating their node
+const mediaReconcileGrace = time.Hour
+
+func (s *mediaService) ReconcileMedia(dryRun bool) (*models.MediaReport, error) {
+	report := &models.MediaReport{
+		DryRun:       dryRun,
+		OrphanFiles:  make([]string, 0),
+		MissingFiles: make([]*models.MissingMediaFile, 0),
+	}
+
+	// files are listed first, a media created meanwhile cannot look orphan
+	listed := time.Now()
+	objects, err := s.storage.List("")
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := s.nodeRepo.GetAllMedia()
+	if err != nil {
+		return nil, err
+	}
+	users, err := s.userRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		for _, filename := range mediaNodeFiles(node) {
+			known[filename] = true
+		}
+	}
+	for _, user := range users {
+		for _, size := range AvatarSizes {
+			known[avatarFileName(user.Id, size)] = true
+		}
+	}
+
+	stored := make(map[string]bool, len(objects))
+	cutoff := time.Now().Add(-mediaReconcileGrace)
+	for _, object := range objects {
+		stored[object.Key] = true
+		if !isMediaKey(object.Key) {
+			continue
+		}
+		report.Files++
+		if known[object.Key] || object.LastModified.After(cutoff) {
+			continue
+		}
+
+		report.OrphanFiles = append(report.OrphanFiles, object.Key)
+		if dryRun {
+			continue
+		}
+		if err := removeMediaFile(s.storage, object.Key); err != nil {
+			logger.Error(fmt.Sprintf("Error removing media file %s: %v", object.Key, err))
+			continue
+		}
+		report.RemovedFiles++
+	}
+
+	for _, node := range nodes {
+		if node.CreatedTimestamp > listed.UnixMilli() {
+			continue
+		}
+		report.Nodes++
+		filename, ok := mediaFileName(node)
+		if ok && stored[filename] {
+			continue
+		}
+		report.MissingFiles = append(report.MissingFiles, &models.MissingMediaFile{
+			NodeId: node.Id,
+			UserId: node.UserId,
+			Path:   filename,
+		})
+	}
+
+	return report, nil
+}
+
+// mediaNodeFiles returns the storage keys of every file of a media node, its variants included
+func mediaNodeFiles(node *models.Node) []string {
+	filename, ok := mediaFileName(node)
+	if !ok {
+		return nil
+	}
+	files := []string{filename}
+	for _, variant := range mediaVariants(node) {
+		files = append(files, variant)
+	}
+	return files
 }
 
-func (s *mediaService) GetMediaFile(nodeId types.Snowflake, userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
+// isMediaKey tells whether a key follows the layout of the media storage, [userId]/... or avatars/[userId]/...
+// Anything else in the storage is left alone.
+func isMediaKey(key string) bool {
+	parts := strings.Split(key, "/")
+	if len(parts) > 0 && parts[0] == "avatars" {
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return false
+	}
+	_, err := strconv.ParseUint(parts[0], 10, 64)
+	return err == nil
+}
+
+func (s *mediaService) GetMediaFile(nodeId types.Snowflake, userId types.Snowflake, width int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*MediaFile, error) {
 	node, err := s.nodeRepo.GetByID(nodeId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if node == nil {
-		return errors.New("node not found")
+	if node == nil || node.UserId != userId {
+		return nil, errors.New("node not found")
 	}
 
 	if allowed, err := authorizer.CanAccessUser(connectedUserId, node.UserId, connectedUserRole); !allowed || err != nil {
-		return errors.New("unauthorized")
+		return nil, errors.New("unauthorized")
 	}
 
-	return nil
+	original, ok := mediaFileName(node)
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	checksum, err := s.mediaChecksum(node, original)
+	if err != nil {
+		return nil, err
+	}
+
+	filename, servedWidth, _ := mediaVariantFileName(node, width)
+	file, info, err := s.storage.Get(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// variants derive from the original, its checksum and the width identify them
+	etag := fmt.Sprintf(`"%s"`, checksum)
+	if servedWidth > 0 {
+		etag = fmt.Sprintf(`"%s-w%d"`, checksum, servedWidth)
+	}
+	// only served to their owner and admins, shared caches must not keep them
+	return &MediaFile{
+		Content: file,
+		Info:    info,
+		ETag:    etag,
+	}, nil
+}
+
+// mediaChecksum returns the SHA-256 of the file of a media node.
+// Media uploaded before checksums were recorded get theirs on first use.
+func (s *mediaService) mediaChecksum(node *models.Node, filename string) (string, error) {
+	if checksum, ok := node.Metadata.GetString("sha256"); ok && checksum != "" {
+		return checksum, nil
+	}
+
+	file, _, err := s.storage.Get(filename)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read media file: %w", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	// a concurrent update of the node wins, the checksum is then computed again on the next request
+	(*node.Metadata)["sha256"] = checksum
+	if _, err := s.nodeRepo.UpdateIfUnchanged(node, node.UpdatedTimestamp); err != nil {
+		logger.Warn(fmt.Sprintf("Checksum of media %d not saved: %v", node.Id, err))
+	}
+	return checksum, nil
 }
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/storage"
+	"structured-notes/types"
+	"testing"
+)
+
+// mediaNodeRepo keeps nodes in memory, the methods the media service does not use panic
+type mediaNodeRepo struct {
+	repositories.NodeRepository
+	nodes map[types.Snowflake]*models.Node
+}
+
+func (r *mediaNodeRepo) GetByID(nodeId types.Snowflake) (*models.Node, error) {
+	return r.nodes[nodeId], nil
+}
+
+func (r *mediaNodeRepo) Delete(nodeId types.Snowflake) error {
+	delete(r.nodes, nodeId)
+	return nil
+}
+
+// ownerAuthorizer lets users reach their own data only
+type ownerAuthorizer struct {
+	permissions.Authorizer
+}
+
+func (ownerAuthorizer) CanAccessUser(connectedId, targetId types.Snowflake, userRole permissions.UserRole) (bool, error) {
+	return connectedId == targetId, nil
+}
+
+func TestDeleteUploadStaysInOwnerFolder(t *testing.T) {
+	const attacker, victim types.Snowflake = 1, 2
+	const forgedId types.Snowflake = 10
+
+	store := storage.NewLocalStorage(t.TempDir())
+	victimFiles := []string{"2/20.png", "2/20-w160.png"}
+	for _, key := range victimFiles {
+		if err := store.Put(key, bytes.NewReader([]byte("private")), 7); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// metadata as a client could have written it before it became server-owned
+	metadata := types.JSONB{
+		"transformed_path": "../2/20.png",
+		"variants":         map[string]interface{}{"160": "../2/20-w160.png"},
+	}
+	repo := &mediaNodeRepo{nodes: map[types.Snowflake]*models.Node{
+		forgedId: {Id: forgedId, UserId: attacker, Role: models.NodeRoleMedia, Metadata: &metadata},
+	}}
+	service := NewMediaService(repo, nil, store, nil)
+
+	// the forged node points to its own, missing, file
+	if _, err := service.GetMediaFile(forgedId, attacker, 0, attacker, permissions.RoleNone, ownerAuthorizer{}); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("GetMediaFile = %v, want %v", err, storage.ErrNotFound)
+	}
+	if err := service.DeleteUpload(forgedId, attacker, permissions.RoleNone, ownerAuthorizer{}); err != nil {
+		t.Fatalf("DeleteUpload: %v", err)
+	}
+	for _, key := range victimFiles {
+		if _, err := store.Stat(key); err != nil {
+			t.Errorf("file %s of user %d: %v", key, victim, err)
+		}
+	}
+}
+
+func TestMediaFileName(t *testing.T) {
+	tests := []struct {
+		name            string
+		role            int
+		transformedPath string
+		want            string
+		wantOk          bool
+	}{
+		{"upload", models.NodeRoleMedia, "10.png", "1/10.png", true},
+		{"extension case kept", models.NodeRoleMedia, "10.JPG", "1/10.JPG", true},
+		{"other node", models.NodeRoleMedia, "20.png", "1/10.png", true},
+		{"other folder", models.NodeRoleMedia, "../2/20.png", "1/10.png", true},
+		{"unknown extension", models.NodeRoleMedia, "10.html", "", false},
+		{"no extension", models.NodeRoleMedia, "../2/20", "", false},
+		{"not a media", models.NodeRoleDocument, "10.png", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := types.JSONB{"transformed_path": test.transformedPath}
+			node := &models.Node{Id: 10, UserId: 1, Role: test.role, Metadata: &metadata}
+			got, ok := mediaFileName(node)
+			if got != test.want || ok != test.wantOk {
+				t.Errorf("mediaFileName = %q, %v, want %q, %v", got, ok, test.want, test.wantOk)
+			}
+		})
+	}
+}
+
+func TestMediaVariantsIgnoreForgedEntries(t *testing.T) {
+	metadata := types.JSONB{
+		"transformed_path": "10.png",
+		"variants": map[string]interface{}{
+			"160": "10-w160.png",
+			"320": "../2/20-w320.jpg",
+			"500": "10-w500.png",  // not a variant width
+			"640": "10-w640.html", // not a variant format
+		},
+	}
+	node := &models.Node{Id: 10, UserId: 1, Role: models.NodeRoleMedia, Metadata: &metadata}
+
+	got := mediaVariants(node)
+	want := map[int]string{160: "1/10-w160.png", 320: "1/10-w320.jpg"}
+	if len(got) != len(want) {
+		t.Fatalf("mediaVariants = %v, want %v", got, want)
+	}
+	for width, key := range want {
+		if got[width] != key {
+			t.Errorf("variant %d = %q, want %q", width, got[width], key)
+		}
+	}
+}
@@ -1,48 +1,185 @@
 package services
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"html"
+	"path"
+	"slices"
+	"strings"
+	"structured-notes/logger"
 	"structured-notes/models"
 	"structured-notes/permissions"
 	"structured-notes/repositories"
+	"structured-notes/storage"
 	"structured-notes/types"
 	"structured-notes/utils"
 	"time"
+
+	"github.com/go-playground/validator/v10"
 )
 
 type NodeService interface {
-	GetAllNodes(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error)
+	GetAllNodes(userId types.Snowflake, tag string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error)
+	GetNodesPage(userId types.Snowflake, tag string, cursor string, limit int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (*models.NodePage, error)
+	GetChildren(userId types.Snowflake, parentId *types.Snowflake, cursor string, limit int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.NodePage, error)
 	GetSharedNodes(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error)
 	GetAllNodeBackup(userId types.Snowflake) ([]*models.Node, error)
 	GetUserUploadsSize(userId types.Snowflake) (int64, error)
 	GetPublicNode(nodeId types.Snowflake) (*models.Node, error)
 	GetNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (map[string]interface{}, error)
 	CreateNode(node *models.Node, userId types.Snowflake) (*models.Node, error)
-	UpdateNode(nodeId types.Snowflake, node *models.Node, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error)
+	UpdateNode(nodeId types.Snowflake, node *models.Node, ifMatch []int64, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error)
+	InstantiateTemplate(templateId types.Snowflake, parentId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, maxUploadsSize float64) (*models.Node, error)
+	PatchNode(nodeId types.Snowflake, patch map[string]interface{}, ifMatch []int64, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error)
+	DuplicateNode(nodeId types.Snowflake, parentId *types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, maxUploadsSize float64) (*models.Node, error)
+	MoveNode(nodeId types.Snowflake, parentId *types.Snowflake, order *int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error)
 	DeleteNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error
+	GetTrash(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error)
+	RestoreNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error)
+	PurgeTrash(retention int) (int64, error)
 }
 
 type nodeService struct {
-	nodeRepo  repositories.NodeRepository
-	permRepo  repositories.PermissionRepository
-	snowflake *utils.Snowflake
+	repos       *repositories.RepositoryManager
+	nodeRepo    repositories.NodeRepository
+	permRepo    repositories.PermissionRepository
+	userRepo    repositories.UserRepository
+	versionRepo repositories.VersionRepository
+	tagRepo     repositories.TagRepository
+	linkRepo    repositories.LinkRepository
+	storage     storage.Storage
+	snowflake   *utils.Snowflake
+}
+
+func NewNodeService(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) NodeService {
+	return newNodeService(repos, store, snowflake)
 }
 
-func NewNodeService(nodeRepo repositories.NodeRepository, permRepo repositories.PermissionRepository, snowflake *utils.Snowflake) NodeService {
+// newNodeService builds a node service over repos, services needing its unexported checks run through it
+func newNodeService(repos *repositories.RepositoryManager, store storage.Storage, snowflake *utils.Snowflake) *nodeService {
 	return &nodeService{
-		nodeRepo:  nodeRepo,
-		permRepo:  permRepo,
-		snowflake: snowflake,
+		repos:       repos,
+		nodeRepo:    repos.Node,
+		permRepo:    repos.Permission,
+		userRepo:    repos.User,
+		versionRepo: repos.Version,
+		tagRepo:     repos.Tag,
+		linkRepo:    repos.Link,
+		storage:     store,
+		snowflake:   snowflake,
 	}
 }
 
-func (s *nodeService) GetAllNodes(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error) {
+// GetAllNodes lists the nodes of a user, only the ones carrying tag when it is not empty
+func (s *nodeService) GetAllNodes(userId types.Snowflake, tag string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error) {
 	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
 		return nil, errors.New("unauthorized")
 	}
+	if tag != "" {
+		return s.nodeRepo.GetAllByTag(userId, models.NormalizeTag(tag))
+	}
 	return s.nodeRepo.GetAll(userId)
 }
 
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 200
+)
+
+// GetNodesPage is GetAllNodes one page at a time, in id order
+func (s *nodeService) GetNodesPage(userId types.Snowflake, tag string, cursor string, limit int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (*models.NodePage, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+
+	var after models.NodeCursor
+	if cursor != "" {
+		if err := utils.DecodeCursor(cursor, &after); err != nil {
+			return nil, err
+		}
+	}
+	if tag != "" {
+		tag = models.NormalizeTag(tag)
+	}
+
+	limit = pageSize(limit)
+	nodes, err := s.nodeRepo.GetAllPage(userId, tag, after.Id, limit+1)
+	if err != nil {
+		return nil, err
+	}
+	return newNodePage(nodes, limit)
+}
+
+// GetChildren lists one level of the tree with the number of children of every node,
+// the root nodes of userId when parentId is nil
+func (s *nodeService) GetChildren(userId types.Snowflake, parentId *types.Snowflake, cursor string, limit int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.NodePage, error) {
+	var after models.NodeCursor
+	if cursor != "" {
+		if err := utils.DecodeCursor(cursor, &after); err != nil {
+			return nil, err
+		}
+	}
+	limit = pageSize(limit)
+
+	var nodes []*models.Node
+	if parentId == nil {
+		if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+			return nil, errors.New("unauthorized")
+		}
+
+		var err error
+		nodes, err = s.nodeRepo.GetRoots(userId, after, limit+1)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		parent, err := s.nodeRepo.GetByID(*parentId)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, errors.New("node not found")
+		}
+
+		allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, parent, permissions.ActionRead)
+		if !allowed || err != nil {
+			return nil, errors.New("unauthorized")
+		}
+
+		nodes, err = s.nodeRepo.GetChildren(*parentId, after, limit+1)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newNodePage(nodes, limit)
+}
+
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(limit, MaxPageSize)
+}
+
+// newNodePage cuts nodes, fetched with one extra row, to limit and sets the cursor of the next page if there is one
+func newNodePage(nodes []*models.Node, limit int) (*models.NodePage, error) {
+	page := &models.NodePage{Nodes: nodes}
+	if len(nodes) <= limit {
+		return page, nil
+	}
+
+	page.Nodes = nodes[:limit]
+	last := page.Nodes[limit-1]
+	cursor, err := utils.EncodeCursor(models.NodeCursor{Role: last.Role, Name: last.Name, Id: last.Id})
+	if err != nil {
+		return nil, err
+	}
+	page.NextCursor = &cursor
+	return page, nil
+}
+
 func (s *nodeService) GetSharedNodes(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error) {
 	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
 		return nil, errors.New("unauthorized")
@@ -67,6 +204,9 @@ func (s *nodeService) GetNode(nodeId types.Snowflake, connectedUserId types.Snow
 	if err != nil {
 		return nil, err
 	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
 	if !allowed || err != nil {
@@ -91,7 +231,40 @@ func (s *nodeService) GetNode(nodeId types.Snowflake, connectedUserId types.Snow
 	}, nil
 }
 
+// ErrMediaRole is returned when a node is created as a media or its role changes from or to media,
+// media nodes only come from uploaded files
+var ErrMediaRole = errors.New("media nodes are created by uploading a file")
+
+// mediaMetadataKeys are set by the server on media nodes, they locate and identify the stored files
+var mediaMetadataKeys = []string{"transformed_path", "variants", "sha256"}
+
+// clientMetadata returns the metadata sent by a client without the media keys, with those of current kept instead
+func clientMetadata(metadata *types.JSONB, current *types.JSONB) *types.JSONB {
+	result := types.JSONB{}
+	if metadata != nil {
+		for key, value := range *metadata {
+			if !slices.Contains(mediaMetadataKeys, key) {
+				result[key] = value
+			}
+		}
+	}
+	if current != nil {
+		for _, key := range mediaMetadataKeys {
+			if value, ok := (*current)[key]; ok {
+				result[key] = value
+			}
+		}
+	}
+	if metadata == nil && len(result) == 0 {
+		return nil
+	}
+	return &result
+}
+
 func (s *nodeService) CreateNode(node *models.Node, userId types.Snowflake) (*models.Node, error) {
+	if node.Role == models.NodeRoleMedia {
+		return nil, ErrMediaRole
+	}
 	escapedHTMLContent := utils.EscapeHTML(node.ContentCompiled)
 
 	description := ""
@@ -106,7 +279,7 @@ func (s *nodeService) CreateNode(node *models.Node, userId types.Snowflake) (*mo
 		Name:             node.Name,
 		Description:      &description,
 		Role:             node.Role,
-		Tags:             node.Tags,
+		Tags:             normalizeTags(node.Tags),
 		Thumbnail:        node.Thumbnail,
 		Theme:            node.Theme,
 		Icon:             node.Icon,
@@ -118,22 +291,69 @@ func (s *nodeService) CreateNode(node *models.Node, userId types.Snowflake) (*mo
 		Content:          node.Content,
 		ContentCompiled:  &escapedHTMLContent,
 		Size:             node.Size,
-		Metadata:         node.Metadata,
+		Metadata:         clientMetadata(node.Metadata, nil),
 		CreatedTimestamp: time.Now().UnixMilli(),
 		UpdatedTimestamp: time.Now().UnixMilli(),
 	}
 
-	if err := s.nodeRepo.Create(createdNode); err != nil {
+	// the tags mirror and the links are only stored along with the node
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		tx := newNodeService(repos, s.storage, s.snowflake)
+		if err := tx.nodeRepo.Create(createdNode); err != nil {
+			return err
+		}
+		if err := tx.setNodeTags(createdNode.Id, userId, createdNode.Tags); err != nil {
+			return err
+		}
+		return saveNodeLinks(tx.linkRepo, createdNode)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return createdNode, nil
 }
 
-func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error) {
+// NodeConflictError is returned when a node was modified since the version the client based its update on
+type NodeConflictError struct {
+	Current *models.Node
+}
+
+func (e *NodeConflictError) Error() string {
+	return "node was modified since it was read"
+}
+
+// UpdateNode replaces a node. When ifMatch is not nil, the update only goes through
+// while the updated_timestamp of the node is one of the listed ones.
+func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, ifMatch []int64, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	var updatedNode *models.Node
+	// the version of the replaced content is only saved along with the update that replaces it
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		var err error
+		updatedNode, err = newNodeService(repos, s.storage, s.snowflake).updateNode(nodeId, node, ifMatch, connectedUserId, connectedUserRole, authorizer, retention)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updatedNode, nil
+}
+
+// updateNode runs UpdateNode in a transaction, the node stays locked from its checks to its update
+func (s *nodeService) updateNode(nodeId types.Snowflake, node *models.Node, ifMatch []int64, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	exists, err := s.nodeRepo.Lock(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("node not found")
+	}
 	dbNode, err := s.nodeRepo.GetByID(nodeId)
 	if err != nil {
 		return nil, err
 	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
 
 	allowed, level, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionUpdate)
 	if err != nil {
@@ -143,6 +363,13 @@ func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, conn
 		return nil, errors.New("unauthorized")
 	}
 
+	if ifMatch != nil && !slices.Contains(ifMatch, dbNode.UpdatedTimestamp) {
+		return nil, &NodeConflictError{Current: dbNode}
+	}
+	if (node.Role == models.NodeRoleMedia) != (dbNode.Role == models.NodeRoleMedia) {
+		return nil, ErrMediaRole
+	}
+
 	if dbNode.UserId != connectedUserId && level < permissions.PermOwner {
 		node.ParentId = dbNode.ParentId
 		node.UserId = dbNode.UserId
@@ -150,6 +377,12 @@ func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, conn
 		node.Access = dbNode.Access
 	}
 
+	if !sameParent(node.ParentId, dbNode.ParentId) {
+		if err := s.checkMove(dbNode, node.ParentId, connectedUserId, connectedUserRole, authorizer); err != nil {
+			return nil, err
+		}
+	}
+
 	escapedHTMLContent := utils.EscapeHTML(node.ContentCompiled)
 	description := ""
 	if node.Description != nil {
@@ -163,7 +396,7 @@ func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, conn
 		Name:             node.Name,
 		Description:      &description,
 		Role:             node.Role,
-		Tags:             node.Tags,
+		Tags:             normalizeTags(node.Tags),
 		Thumbnail:        node.Thumbnail,
 		Theme:            node.Theme,
 		Icon:             node.Icon,
@@ -174,26 +407,592 @@ func (s *nodeService) UpdateNode(nodeId types.Snowflake, node *models.Node, conn
 		Order:            node.Order,
 		Content:          node.Content,
 		ContentCompiled:  &escapedHTMLContent,
-		Metadata:         node.Metadata,
-		UpdatedTimestamp: time.Now().UnixMilli(),
+		Metadata:         clientMetadata(node.Metadata, dbNode.Metadata),
+		UpdatedTimestamp: nextTimestamp(dbNode.UpdatedTimestamp),
 	}
 
-	if err := s.nodeRepo.Update(updatedNode); err != nil {
+	// dbNode may predate the lock when the transaction started earlier, e.g. in a batch
+	updated, err := s.nodeRepo.UpdateIfUnchanged(updatedNode, dbNode.UpdatedTimestamp)
+	if err != nil {
 		return nil, err
 	}
+	if !updated {
+		current, err := s.nodeRepo.GetByID(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, errors.New("node not found")
+		}
+		return nil, &NodeConflictError{Current: current}
+	}
+	if err := saveNodeVersion(s.versionRepo, s.snowflake, dbNode, updatedNode, connectedUserId, retention); err != nil {
+		return nil, err
+	}
+	if utils.StringValue(updatedNode.Tags) != utils.StringValue(normalizeTags(dbNode.Tags)) {
+		// tags belong to the owner of the node, whoever edits it
+		if err := s.setNodeTags(nodeId, dbNode.UserId, updatedNode.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if utils.StringValue(updatedNode.Content) != utils.StringValue(dbNode.Content) {
+		if err := saveNodeLinks(s.linkRepo, updatedNode); err != nil {
+			return nil, err
+		}
+	}
 	return updatedNode, nil
 }
 
+// PatchNode applies a JSON merge patch (RFC 7396) to the stored node and saves it through UpdateNode.
+// Without ifMatch, the patch is applied again on the fresh node when a concurrent update goes through first.
+func (s *nodeService) PatchNode(nodeId types.Snowflake, patch map[string]interface{}, ifMatch []int64, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	for attempt := 1; ; attempt++ {
+		dbNode, err := s.nodeRepo.GetByID(nodeId)
+		if err != nil {
+			return nil, err
+		}
+		if dbNode == nil {
+			return nil, errors.New("node not found")
+		}
+
+		node, err := mergeNodePatch(dbNode, patch)
+		if err != nil {
+			return nil, err
+		}
+
+		expected := ifMatch
+		if expected == nil {
+			expected = []int64{dbNode.UpdatedTimestamp}
+		}
+
+		updatedNode, err := s.UpdateNode(nodeId, node, expected, connectedUserId, connectedUserRole, authorizer, retention)
+		var conflict *NodeConflictError
+		if errors.As(err, &conflict) && ifMatch == nil && attempt < maxPatchAttempts {
+			continue
+		}
+		return updatedNode, err
+	}
+}
+
+const maxPatchAttempts = 3
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+var nodeValidator = func() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding") // same rules as the request bindings of models.Node
+	return v
+}()
+
+// mergeNodePatch returns a copy of dbNode with the patch applied, fields not settable through the API are left untouched
+func mergeNodePatch(dbNode *models.Node, patch map[string]interface{}) (*models.Node, error) {
+	encoded, err := json.Marshal(dbNode)
+	if err != nil {
+		return nil, err
+	}
+	var document map[string]interface{}
+	if err := json.Unmarshal(encoded, &document); err != nil {
+		return nil, err
+	}
+
+	merged, err := json.Marshal(utils.MergePatch(document, patch))
+	if err != nil {
+		return nil, err
+	}
+	var node models.Node
+	if err := json.Unmarshal(merged, &node); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	node.Id = dbNode.Id
+	node.Size = dbNode.Size
+	node.CreatedTimestamp = dbNode.CreatedTimestamp
+	node.UpdatedTimestamp = dbNode.UpdatedTimestamp
+	node.DeletedTimestamp = dbNode.DeletedTimestamp
+	node.Permissions = nil
+
+	if err := nodeValidator.Struct(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (s *nodeService) MoveNode(nodeId types.Snowflake, parentId *types.Snowflake, order *int, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error) {
+	var moved *models.Node
+	// the node and the new parent chain stay locked from the checks to the move, concurrent moves cannot make a cycle
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		tx := newNodeService(repos, s.storage, s.snowflake)
+		if _, err := tx.nodeRepo.LockAncestors(nodeId); err != nil {
+			return err
+		}
+		dbNode, err := tx.nodeRepo.GetByID(nodeId)
+		if err != nil {
+			return err
+		}
+		if dbNode == nil {
+			return errors.New("node not found")
+		}
+
+		// taking a node out of its current parent is as destructive as deleting it there
+		allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionDelete)
+		if !allowed || err != nil {
+			return errors.New("unauthorized")
+		}
+
+		if err := tx.checkMove(dbNode, parentId, connectedUserId, connectedUserRole, authorizer); err != nil {
+			return err
+		}
+
+		if err := tx.nodeRepo.Move(nodeId, parentId, order, nextTimestamp(dbNode.UpdatedTimestamp)); err != nil {
+			return err
+		}
+		moved, err = tx.nodeRepo.GetByID(nodeId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+// DuplicateNode deep copies a node and its descendants under parentId, or next to the original when nil.
+// The copies belong to the connected user, media files are copied along.
+func (s *nodeService) DuplicateNode(nodeId types.Snowflake, parentId *types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, maxUploadsSize float64) (*models.Node, error) {
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	if parentId == nil {
+		parentId = dbNode.ParentId
+	}
+	if parentId != nil {
+		if _, err := s.checkParent(dbNode.Role, *parentId, connectedUserId, connectedUserRole, authorizer); err != nil {
+			return nil, err
+		}
+	}
+
+	nodes, err := s.nodeRepo.GetSubtree(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	return s.copyNodes(nodes, parentId, connectedUserId, maxUploadsSize, nil)
+}
+
+// InstantiateTemplate copies a template and its descendants under parentId as documents owned by the connected user.
+// Template variables are substituted in the names and contents, see templateVariables.
+func (s *nodeService) InstantiateTemplate(templateId types.Snowflake, parentId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, maxUploadsSize float64) (*models.Node, error) {
+	template, err := s.nodeRepo.GetByID(templateId)
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, errors.New("template not found")
+	}
+	if template.Role != models.NodeRoleTemplate {
+		return nil, errors.New("node is not a template")
+	}
+
+	// templates are shared like any other node
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, template, permissions.ActionRead)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	parent, err := s.checkParent(models.NodeRoleDocument, parentId, connectedUserId, connectedUserRole, authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByID(connectedUserId)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.New("user not found")
+	}
+
+	nodes, err := s.nodeRepo.GetSubtree(templateId)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := templateVariables(time.Now(), user, parent)
+	replacer := strings.NewReplacer(variables...)
+	// content_compiled is HTML, values are escaped there
+	for i := 1; i < len(variables); i += 2 {
+		variables[i] = html.EscapeString(variables[i])
+	}
+	htmlReplacer := strings.NewReplacer(variables...)
+
+	return s.copyNodes(nodes, &parentId, connectedUserId, maxUploadsSize, func(copied *models.Node) {
+		if copied.Role == models.NodeRoleTemplate {
+			copied.Role = models.NodeRoleDocument
+		}
+
+		name := []rune(replacer.Replace(copied.Name))
+		if len(name) > 50 {
+			name = name[:50]
+		}
+		copied.Name = string(name)
+
+		if copied.Role == models.NodeRoleMedia {
+			return
+		}
+		if copied.Content != nil {
+			content := replacer.Replace(*copied.Content)
+			copied.Content = &content
+		}
+		if copied.ContentCompiled != nil {
+			contentCompiled := htmlReplacer.Replace(*copied.ContentCompiled)
+			copied.ContentCompiled = &contentCompiled
+		}
+	})
+}
+
+// templateVariables lists the variables of templates and their values as old, new pairs for strings.NewReplacer.
+// {{parent.name}} is the node the template is instantiated under.
+func templateVariables(now time.Time, user *models.User, parent *models.Node) []string {
+	return []string{
+		"{{date}}", now.Format("2006-01-02"),
+		"{{time}}", now.Format("15:04"),
+		"{{datetime}}", now.Format("2006-01-02 15:04"),
+		"{{user.username}}", user.Username,
+		"{{user.firstname}}", utils.StringValue(user.Firstname),
+		"{{user.lastname}}", utils.StringValue(user.Lastname),
+		"{{parent.name}}", parent.Name,
+	}
+}
+
+// copyNodes copies a subtree, as returned by GetSubtree, under parentId on behalf of ownerId.
+// Media files are copied along and edit, when set, can alter every copy before it is stored.
+func (s *nodeService) copyNodes(nodes []*models.Node, parentId *types.Snowflake, ownerId types.Snowflake, maxUploadsSize float64, edit func(copied *models.Node)) (*models.Node, error) {
+	var mediaSize int64
+	for _, node := range nodes {
+		if node.Role == models.NodeRoleMedia && node.Size != nil {
+			mediaSize += *node.Size
+		}
+	}
+	if mediaSize > 0 {
+		totalSize, err := s.nodeRepo.GetUserUploadsSize(ownerId)
+		if err != nil {
+			return nil, err
+		}
+		if totalSize+mediaSize > int64(maxUploadsSize) {
+			return nil, errors.New("total size of uploads exceeds the limit")
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	newIds := make(map[types.Snowflake]types.Snowflake, len(nodes))
+	copies := make([]*models.Node, 0, len(nodes))
+	copiedFiles := make([]string, 0)
+	mediaLinks := make([]string, 0)
+
+	removeCopiedFiles := func() {
+		for _, filename := range copiedFiles {
+			if err := removeMediaFile(s.storage, filename); err != nil {
+				logger.Error(fmt.Sprintf("Error removing media file %s: %v", filename, err))
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		copied := *node
+		copied.Id = s.snowflake.Generate()
+		copied.UserId = ownerId
+		copied.CreatedTimestamp = now
+		copied.UpdatedTimestamp = now
+		copied.DeletedTimestamp = nil
+		copied.Permissions = nil
+		newIds[node.Id] = copied.Id
+
+		if node.Id == nodes[0].Id {
+			copied.ParentId = parentId
+		} else {
+			newParentId := newIds[*node.ParentId]
+			copied.ParentId = &newParentId
+		}
+
+		if node.Role == models.NodeRoleMedia {
+			if filename, ok := mediaFileName(node); ok {
+				transformedPath := fmt.Sprintf("%d%s", copied.Id, path.Ext(filename))
+				newFilename := path.Join(fmt.Sprintf("%d", ownerId), transformedPath)
+				if err := copyMediaFile(s.storage, filename, newFilename); err != nil {
+					removeCopiedFiles()
+					return nil, err
+				}
+				copiedFiles = append(copiedFiles, newFilename)
+
+				metadata := types.JSONB{}
+				for key, value := range *node.Metadata {
+					metadata[key] = value
+				}
+				metadata["transformed_path"] = transformedPath
+				copied.Metadata = &metadata
+				copied.ContentCompiled = &transformedPath
+
+				variantFiles, err := copyMediaVariants(s.storage, node, &copied)
+				copiedFiles = append(copiedFiles, variantFiles...)
+				if err != nil {
+					removeCopiedFiles()
+					return nil, err
+				}
+
+				// documents embed media as /media/[userId]/[nodeId].ext
+				mediaLinks = append(mediaLinks, filename, newFilename)
+			}
+		}
+		copies = append(copies, &copied)
+	}
+
+	if len(mediaLinks) > 0 {
+		replacer := strings.NewReplacer(mediaLinks...)
+		for _, copied := range copies {
+			if copied.Role == models.NodeRoleMedia {
+				continue
+			}
+			if copied.Content != nil {
+				content := replacer.Replace(*copied.Content)
+				copied.Content = &content
+			}
+			if copied.ContentCompiled != nil {
+				contentCompiled := replacer.Replace(*copied.ContentCompiled)
+				copied.ContentCompiled = &contentCompiled
+			}
+		}
+	}
+
+	if edit != nil {
+		for _, copied := range copies {
+			edit(copied)
+		}
+	}
+
+	// the copies are only stored along with their tags and links
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		tx := newNodeService(repos, s.storage, s.snowflake)
+		if err := tx.nodeRepo.CreateMany(copies); err != nil {
+			return err
+		}
+		for _, copied := range copies {
+			if err := tx.setNodeTags(copied.Id, ownerId, copied.Tags); err != nil {
+				return err
+			}
+			if err := saveNodeLinks(tx.linkRepo, copied); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		removeCopiedFiles()
+		return nil, err
+	}
+	return copies[0], nil
+}
+
+// nextTimestamp returns the current time, moved past previous so that every update changes the ETag of a node
+func nextTimestamp(previous int64) int64 {
+	return max(time.Now().UnixMilli(), previous+1)
+}
+
+// setNodeTags mirrors the comma separated tags of a node into the tags tables
+func (s *nodeService) setNodeTags(nodeId, ownerId types.Snowflake, tags *string) error {
+	now := time.Now().UnixMilli()
+	nodeTags := make([]*models.Tag, 0)
+	for _, name := range models.ParseTags(tags) {
+		nodeTags = append(nodeTags, &models.Tag{
+			Id:               s.snowflake.Generate(),
+			UserId:           ownerId,
+			Name:             name,
+			CreatedTimestamp: now,
+		})
+	}
+	return s.tagRepo.SetNodeTags(nodeId, nodeTags)
+}
+
+// checkMove validates placing dbNode under parentId, nil meaning the root
+func (s *nodeService) checkMove(dbNode *models.Node, parentId *types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
+	// owners of ancestors inherit full access, see PermissionRepository.HasPermission
+	currentOwner := dbNode.UserId
+	if dbNode.ParentId != nil {
+		currentParent, err := s.nodeRepo.GetByID(*dbNode.ParentId)
+		if err != nil {
+			return err
+		}
+		if currentParent != nil {
+			currentOwner = currentParent.UserId
+		}
+	}
+
+	targetOwner := dbNode.UserId
+	if parentId == nil {
+		if dbNode.Role != models.NodeRoleWorkspace && dbNode.Role != models.NodeRoleMedia {
+			return errors.New("only workspaces and media can be moved to the root")
+		}
+	} else {
+		if *parentId == dbNode.Id {
+			return errors.New("a node cannot be its own parent")
+		}
+
+		parent, err := s.checkParent(dbNode.Role, *parentId, connectedUserId, connectedUserRole, authorizer)
+		if err != nil {
+			return err
+		}
+
+		// locked until the end of the transaction, see MoveNode
+		ancestors, err := s.nodeRepo.LockAncestors(parent.Id)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(ancestors, dbNode.Id) {
+			return errors.New("a node cannot be moved under one of its descendants")
+		}
+		targetOwner = parent.UserId
+	}
+
+	// changing trees changes who inherits access to the whole subtree
+	if targetOwner != currentOwner && dbNode.UserId != connectedUserId && !authorizer.IsAppAdmin(connectedUserRole) {
+		return errors.New("only the owner can move a node to another owner's tree")
+	}
+	return nil
+}
+
+// checkParent validates that a node of the given role can be created under parentId by the connected user
+func (s *nodeService) checkParent(role int, parentId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error) {
+	parent, err := s.nodeRepo.GetByID(parentId)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, errors.New("parent node not found")
+	}
+
+	if !canNestUnder(role, parent.Role) {
+		return nil, fmt.Errorf("a node with role %d cannot be placed under a node with role %d", role, parent.Role)
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, parent, permissions.ActionUpdate)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+	return parent, nil
+}
+
+// canNestUnder tells if a node of the given role may be a child of a node of parentRole
+func canNestUnder(role, parentRole int) bool {
+	switch role {
+	case models.NodeRoleWorkspace:
+		return false
+	case models.NodeRoleCategory:
+		return parentRole == models.NodeRoleWorkspace || parentRole == models.NodeRoleCategory
+	default:
+		return parentRole != models.NodeRoleMedia
+	}
+}
+
+func sameParent(a, b *types.Snowflake) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 func (s *nodeService) DeleteNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) error {
 	dbNode, err := s.nodeRepo.GetByID(nodeId)
 	if err != nil {
 		return err
 	}
+	if dbNode == nil {
+		return errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionDelete)
 	if !allowed || err != nil {
 		return errors.New("unauthorized")
 	}
 
-	return s.nodeRepo.Delete(nodeId)
+	// nodes go to the trash first, PurgeTrash deletes them for good
+	return s.nodeRepo.Trash(nodeId, time.Now().UnixMilli())
+}
+
+func (s *nodeService) GetTrash(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Node, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+	return s.nodeRepo.GetTrash(userId)
+}
+
+// RestoreNode takes a trashed subtree out of the trash, back under its original parent
+func (s *nodeService) RestoreNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error) {
+	dbNode, err := s.nodeRepo.GetTrashedByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found in trash")
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionDelete)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+
+	if dbNode.ParentId != nil {
+		parent, err := s.nodeRepo.GetByID(*dbNode.ParentId)
+		if err != nil {
+			return nil, err
+		}
+		if parent == nil {
+			return nil, errors.New("parent node is in the trash, restore it first")
+		}
+	}
+
+	if err := s.nodeRepo.Restore(nodeId); err != nil {
+		return nil, err
+	}
+	dbNode.DeletedTimestamp = nil
+	return dbNode, nil
+}
+
+// PurgeTrash deletes for good the nodes trashed more than retention days ago, along with their media files
+func (s *nodeService) PurgeTrash(retention int) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+	before := time.Now().AddDate(0, 0, -retention).UnixMilli()
+
+	nodes, err := s.nodeRepo.GetTrashedBefore(before)
+	if err != nil {
+		return 0, err
+	}
+	if len(nodes) == 0 {
+		return 0, nil
+	}
+
+	purged, err := s.nodeRepo.DeleteTrashedBefore(before)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, node := range nodes {
+		if node.Role != models.NodeRoleMedia {
+			continue
+		}
+		for _, filename := range mediaNodeFiles(node) {
+			if err := removeMediaFile(s.storage, filename); err != nil {
+				logger.Error(fmt.Sprintf("Error removing media file %s: %v", filename, err))
+			}
+		}
+	}
+	return purged, nil
 }
@@ -38,6 +38,9 @@ func (s *permissionService) GetNodePermissions(nodeId types.Snowflake, connected
 	if err != nil {
 		return nil, err
 	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionManagePermissions)
 	if !allowed || err != nil {
@@ -60,6 +63,9 @@ func (s *permissionService) CreatePermission(nodeId, userId types.Snowflake, per
 	if err != nil {
 		return nil, err
 	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionManagePermissions)
 	if !allowed || err != nil {
@@ -90,6 +96,9 @@ func (s *permissionService) UpdatePermission(id types.Snowflake, permission int,
 	if err != nil {
 		return err
 	}
+	if dbNode == nil {
+		return errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionManagePermissions)
 	if !allowed || err != nil {
@@ -113,6 +122,9 @@ func (s *permissionService) DeletePermission(id types.Snowflake, connectedUserId
 	if err != nil {
 		return err
 	}
+	if dbNode == nil {
+		return errors.New("node not found")
+	}
 
 	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionManagePermissions)
 	if (!allowed || err != nil) && perm.UserId != connectedUserId {
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/repositories"
+	"structured-notes/types"
+	"structured-notes/utils"
+	"unicode"
+)
+
+const (
+	searchDefaultLimit  = 20
+	searchMaxLimit      = 100
+	searchSnippetRadius = 80
+)
+
+type SearchService interface {
+	Search(connectedUserId types.Snowflake, text string, query models.SearchQuery) ([]*models.SearchHit, error)
+}
+
+type searchService struct {
+	searchRepo repositories.SearchRepository
+}
+
+func NewSearchService(searchRepo repositories.SearchRepository) SearchService {
+	return &searchService{
+		searchRepo: searchRepo,
+	}
+}
+
+// Search looks for text in the nodes the connected user can read, query holds the filters and pagination
+func (s *searchService) Search(connectedUserId types.Snowflake, text string, query models.SearchQuery) ([]*models.SearchHit, error) {
+	query.Terms = searchTerms(text)
+	if len(query.Terms) == 0 {
+		return nil, errors.New("search query is empty")
+	}
+	if query.Limit <= 0 || query.Limit > searchMaxLimit {
+		query.Limit = searchDefaultLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+
+	hits, err := s.searchRepo.Search(connectedUserId, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hit := range hits {
+		source := utils.StringValue(hit.Node.Content)
+		if source == "" {
+			source = utils.StringValue(hit.Node.Description)
+		}
+		if source == "" {
+			source = hit.Node.Name
+		}
+		hit.Snippet = utils.Snippet(source, query.Terms, searchSnippetRadius)
+		// content is only loaded for the snippet
+		hit.Node.Content = nil
+	}
+	return hits, nil
+}
+
+// searchTerms splits text into lowercase words, dropping punctuation and search operators
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
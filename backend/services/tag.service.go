@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/types"
+	"time"
+)
+
+type TagService interface {
+	GetTags(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Tag, error)
+	RenameTag(tagId types.Snowflake, name string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (*models.Tag, error)
+}
+
+type tagService struct {
+	tagRepo repositories.TagRepository
+}
+
+func NewTagService(tagRepo repositories.TagRepository) TagService {
+	return &tagService{
+		tagRepo: tagRepo,
+	}
+}
+
+func (s *tagService) GetTags(userId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) ([]*models.Tag, error) {
+	if connectedUserId != userId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+	return s.tagRepo.GetByUser(userId)
+}
+
+// RenameTag renames a tag on every node carrying it.
+// Renaming to the name of another tag of the same user merges both, the other tag is returned.
+func (s *tagService) RenameTag(tagId types.Snowflake, name string, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole) (*models.Tag, error) {
+	tag, err := s.tagRepo.GetByID(tagId)
+	if err != nil {
+		return nil, err
+	}
+	if tag == nil {
+		return nil, errors.New("tag not found")
+	}
+	if connectedUserId != tag.UserId && connectedUserRole != permissions.RoleAdministrator {
+		return nil, errors.New("unauthorized")
+	}
+
+	name = models.NormalizeTag(name)
+	if name == "" || strings.Contains(name, ",") {
+		return nil, errors.New("invalid tag name")
+	}
+	if name == tag.Name {
+		return tag, nil
+	}
+
+	now := time.Now().UnixMilli()
+	target, err := s.tagRepo.GetByName(tag.UserId, name)
+	if err != nil {
+		return nil, err
+	}
+	if target != nil {
+		if err := s.tagRepo.Merge(tag, target, now); err != nil {
+			return nil, err
+		}
+		return target, nil
+	}
+
+	if err := s.tagRepo.Rename(tag, name, now); err != nil {
+		return nil, err
+	}
+	tag.Name = name
+	return tag, nil
+}
+
+// normalizeTags rewrites the comma separated tags of a node in their normalized form
+func normalizeTags(tags *string) *string {
+	if tags == nil {
+		return nil
+	}
+	normalized := models.JoinTags(models.ParseTags(tags))
+	return &normalized
+}
@@ -0,0 +1,415 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"structured-notes/models"
+	"structured-notes/repositories"
+	"structured-notes/types"
+	"structured-notes/utils"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUploadNotFound   = errors.New("upload not found")
+	ErrUploadIncomplete = errors.New("upload is not complete")
+	ErrChecksumRequired = errors.New("sha256 checksum required")
+	ErrChecksumMismatch = errors.New("sha256 checksum does not match the received file")
+	ErrChunkTooLarge    = errors.New("chunk exceeds the size of the upload")
+)
+
+type UploadService interface {
+	// CreateUpload opens a resumable upload, its type, size and the quota of the user are checked before any byte is sent
+	CreateUpload(request *models.UploadRequest, userId types.Snowflake, area UploadArea, limits MediaLimits) (*models.Upload, error)
+	GetUpload(uploadId types.Snowflake, userId types.Snowflake, area UploadArea) (*models.Upload, error)
+	// WriteChunk appends a chunk to an upload, offset must be the current offset of the upload or an UploadOffsetError is returned.
+	// The bytes received before a dropped connection are kept, the client resumes from the offset of GetUpload.
+	WriteChunk(uploadId types.Snowflake, userId types.Snowflake, offset int64, chunk io.Reader, area UploadArea) (*models.Upload, error)
+	// CompleteUpload checks the SHA-256 of a fully received upload and stores it as a media node.
+	// The checksum given here takes precedence over the one given on creation, one of them is required.
+	CompleteUpload(uploadId types.Snowflake, userId types.Snowflake, checksum string, area UploadArea, limits MediaLimits) (*models.Node, error)
+	AbortUpload(uploadId types.Snowflake, userId types.Snowflake, area UploadArea) error
+	// PurgeUploads removes the uploads left unfinished past their expiry
+	PurgeUploads(area UploadArea) (int, error)
+}
+
+// UploadArea is where resumable uploads are kept until completed, see the [Media] configuration
+type UploadArea struct {
+	Directory string
+	Expiry    int // hours without a chunk before an upload is purged
+}
+
+// UploadOffsetError is returned when a chunk does not start where the upload stands
+type UploadOffsetError struct {
+	Current *models.Upload
+}
+
+func (e *UploadOffsetError) Error() string {
+	return "chunk offset does not match the upload offset"
+}
+
+type uploadService struct {
+	nodeRepo  repositories.NodeRepository
+	media     MediaService
+	snowflake *utils.Snowflake
+	// uploads are written by one request at a time
+	locks sync.Map
+}
+
+func NewUploadService(nodeRepo repositories.NodeRepository, media MediaService, snowflake *utils.Snowflake) UploadService {
+	return &uploadService{
+		nodeRepo:  nodeRepo,
+		media:     media,
+		snowflake: snowflake,
+	}
+}
+
+func (s *uploadService) lock(uploadId types.Snowflake) func() {
+	value, _ := s.locks.LoadOrStore(uploadId, &sync.Mutex{})
+	mutex := value.(*sync.Mutex)
+	mutex.Lock()
+	return mutex.Unlock
+}
+
+// lockUpload locks an upload and reads it, the returned function releases the lock.
+// The upload is looked up before its lock is taken, ids of missing uploads would leave their lock behind.
+func (s *uploadService) lockUpload(area UploadArea, userId types.Snowflake, uploadId types.Snowflake) (*models.Upload, func(), error) {
+	if _, err := readUpload(area, userId, uploadId); err != nil {
+		return nil, nil, err
+	}
+
+	unlock := s.lock(uploadId)
+	upload, err := readUpload(area, userId, uploadId)
+	if err != nil {
+		// removed while the lock was awaited
+		if errors.Is(err, ErrUploadNotFound) {
+			s.locks.Delete(uploadId)
+		}
+		unlock()
+		return nil, nil, err
+	}
+	return upload, unlock, nil
+}
+
+// uploadPaths returns the files of an upload: its description and the bytes received so far
+func uploadPaths(area UploadArea, userId types.Snowflake, uploadId types.Snowflake) (string, string) {
+	base := filepath.Join(area.Directory, fmt.Sprintf("%d", userId), fmt.Sprintf("%d", uploadId))
+	return base + ".json", base + ".part"
+}
+
+func (s *uploadService) CreateUpload(request *models.UploadRequest, userId types.Snowflake, area UploadArea, limits MediaLimits) (*models.Upload, error) {
+	filename := path.Base(strings.ReplaceAll(request.Filename, "\\", "/"))
+	mimeType, ok := utils.MediaTypeOf(filename)
+	if !ok || !slices.Contains(limits.SupportedTypes, mimeType) {
+		return nil, utils.ErrUnknownMediaType
+	}
+	if request.Size > limits.MaxSizeOf(mimeType) {
+		return nil, errors.New("file size exceeds the limit")
+	}
+
+	// pending uploads hold their share of the quota until they complete or expire
+	totalSize, err := s.nodeRepo.GetUserUploadsSize(userId)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := listUploads(area, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, upload := range pending {
+		totalSize += upload.Size
+	}
+	if totalSize+request.Size > int64(limits.MaxUploadsSize) {
+		return nil, errors.New("total size of uploads exceeds the limit")
+	}
+
+	now := time.Now()
+	upload := &models.Upload{
+		Id:               s.snowflake.Generate(),
+		UserId:           userId,
+		Filename:         filename,
+		MimeType:         mimeType,
+		Size:             request.Size,
+		SHA256:           strings.ToLower(request.SHA256),
+		CreatedTimestamp: now.UnixMilli(),
+		ExpiresTimestamp: now.Add(time.Duration(area.Expiry) * time.Hour).UnixMilli(),
+	}
+
+	if err := os.MkdirAll(filepath.Join(area.Directory, fmt.Sprintf("%d", userId)), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+	infoPath, partPath := uploadPaths(area, userId, upload.Id)
+	if err := os.WriteFile(partPath, nil, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+	if err := writeUpload(infoPath, upload); err != nil {
+		os.Remove(partPath)
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (s *uploadService) GetUpload(uploadId types.Snowflake, userId types.Snowflake, area UploadArea) (*models.Upload, error) {
+	return readUpload(area, userId, uploadId)
+}
+
+func (s *uploadService) WriteChunk(uploadId types.Snowflake, userId types.Snowflake, offset int64, chunk io.Reader, area UploadArea) (*models.Upload, error) {
+	upload, unlock, err := s.lockUpload(area, userId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if offset != upload.Offset {
+		return nil, &UploadOffsetError{Current: upload}
+	}
+
+	infoPath, partPath := uploadPaths(area, userId, uploadId)
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	// one byte past the declared size tells an oversized chunk, which is dropped whole
+	remaining := upload.Size - upload.Offset
+	written, copyErr := io.Copy(file, io.LimitReader(chunk, remaining+1))
+	if written > remaining {
+		if err := file.Truncate(upload.Offset); err != nil {
+			return nil, fmt.Errorf("failed to truncate upload file: %w", err)
+		}
+		return nil, ErrChunkTooLarge
+	}
+	upload.Offset += written
+
+	upload.ExpiresTimestamp = time.Now().Add(time.Duration(area.Expiry) * time.Hour).UnixMilli()
+	if err := writeUpload(infoPath, upload); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return nil, fmt.Errorf("failed to receive chunk: %w", copyErr)
+	}
+	return upload, nil
+}
+
+func (s *uploadService) CompleteUpload(uploadId types.Snowflake, userId types.Snowflake, checksum string, area UploadArea, limits MediaLimits) (*models.Node, error) {
+	upload, unlock, err := s.lockUpload(area, userId, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if upload.Offset != upload.Size {
+		return nil, ErrUploadIncomplete
+	}
+	if checksum == "" {
+		checksum = upload.SHA256
+	}
+	if checksum == "" {
+		return nil, ErrChecksumRequired
+	}
+
+	_, partPath := uploadPaths(area, userId, uploadId)
+	file, err := os.Open(partPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, fmt.Errorf("failed to read upload file: %w", err)
+	}
+	// the received bytes are corrupt somewhere, the client starts over
+	if hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(checksum) {
+		file.Close()
+		if err := s.removeUpload(area, userId, uploadId); err != nil {
+			return nil, err
+		}
+		return nil, ErrChecksumMismatch
+	}
+
+	// the upload is kept on failure, e.g. for a retry once the user freed some quota
+	node, err := s.media.UploadFileAt(upload.Filename, file, upload.Size, userId, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Close()
+	if err := s.removeUpload(area, userId, uploadId); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (s *uploadService) AbortUpload(uploadId types.Snowflake, userId types.Snowflake, area UploadArea) error {
+	_, unlock, err := s.lockUpload(area, userId, uploadId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.removeUpload(area, userId, uploadId)
+}
+
+func (s *uploadService) PurgeUploads(area UploadArea) (int, error) {
+	users, err := os.ReadDir(area.Directory)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	now := time.Now()
+	purged := 0
+	errs := make([]error, 0)
+	for _, user := range users {
+		userId, err := strconv.ParseUint(user.Name(), 10, 64)
+		if err != nil || !user.IsDir() {
+			continue
+		}
+		dir := filepath.Join(area.Directory, user.Name())
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list uploads: %w", err))
+			continue
+		}
+
+		for _, entry := range entries {
+			name, isInfo := strings.CutSuffix(entry.Name(), ".json")
+			uploadId, err := strconv.ParseUint(strings.TrimSuffix(name, ".part"), 10, 64)
+			if !isInfo {
+				// files left by a failed removal, or a crash while creating an upload
+				info, statErr := entry.Info()
+				if statErr != nil || now.Sub(info.ModTime()) < time.Duration(area.Expiry)*time.Hour {
+					continue
+				}
+				if err == nil {
+					if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%d.json", uploadId))); err == nil {
+						continue
+					}
+				}
+				if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					errs = append(errs, fmt.Errorf("failed to remove upload file: %w", err))
+				}
+				continue
+			}
+			if err != nil {
+				continue
+			}
+
+			// a chunk may have come in since the listing
+			upload, unlock, err := s.lockUpload(area, types.Snowflake(userId), types.Snowflake(uploadId))
+			if err == nil {
+				if upload.ExpiresTimestamp <= now.UnixMilli() {
+					err = s.removeUpload(area, upload.UserId, upload.Id)
+					if err == nil {
+						purged++
+					}
+				}
+				unlock()
+			}
+			if err != nil && !errors.Is(err, ErrUploadNotFound) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// removeUpload deletes the files of an upload, its lock must be held
+func (s *uploadService) removeUpload(area UploadArea, userId types.Snowflake, uploadId types.Snowflake) error {
+	infoPath, partPath := uploadPaths(area, userId, uploadId)
+	// without its description, the upload is gone even if its bytes could not be removed
+	if err := os.Remove(infoPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	s.locks.Delete(uploadId)
+	if err := os.Remove(partPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove upload file: %w", err)
+	}
+	return nil
+}
+
+// readUpload loads the description of an upload, its offset is the size of the bytes received
+func readUpload(area UploadArea, userId types.Snowflake, uploadId types.Snowflake) (*models.Upload, error) {
+	infoPath, partPath := uploadPaths(area, userId, uploadId)
+	content, err := os.ReadFile(infoPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	var upload models.Upload
+	if err := json.Unmarshal(content, &upload); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	info, err := os.Stat(partPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload file: %w", err)
+	}
+	upload.Offset = info.Size()
+	return &upload, nil
+}
+
+// writeUpload replaces the description of an upload at once, so that readers never see it half written
+func writeUpload(infoPath string, upload *models.Upload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(infoPath+".tmp", content, 0600); err != nil {
+		return fmt.Errorf("failed to write upload: %w", err)
+	}
+	if err := os.Rename(infoPath+".tmp", infoPath); err != nil {
+		return fmt.Errorf("failed to write upload: %w", err)
+	}
+	return nil
+}
+
+// listUploads returns the pending uploads of a user
+func listUploads(area UploadArea, userId types.Snowflake) ([]*models.Upload, error) {
+	entries, err := os.ReadDir(filepath.Join(area.Directory, fmt.Sprintf("%d", userId)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return make([]*models.Upload, 0), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	uploads := make([]*models.Upload, 0)
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found {
+			continue
+		}
+		uploadId, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		upload, err := readUpload(area, userId, types.Snowflake(uploadId))
+		if errors.Is(err, ErrUploadNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
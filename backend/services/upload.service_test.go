@@ -0,0 +1,31 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"structured-notes/types"
+	"testing"
+)
+
+func TestUnknownUploadsLeaveNoLock(t *testing.T) {
+	service := &uploadService{}
+	area := UploadArea{Directory: t.TempDir(), Expiry: 1}
+	const userId types.Snowflake = 1
+
+	for uploadId := types.Snowflake(1); uploadId <= 3; uploadId++ {
+		if _, err := service.WriteChunk(uploadId, userId, 0, strings.NewReader("chunk"), area); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("WriteChunk = %v, want %v", err, ErrUploadNotFound)
+		}
+		if _, err := service.CompleteUpload(uploadId, userId, "", area, MediaLimits{}); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("CompleteUpload = %v, want %v", err, ErrUploadNotFound)
+		}
+		if err := service.AbortUpload(uploadId, userId, area); !errors.Is(err, ErrUploadNotFound) {
+			t.Errorf("AbortUpload = %v, want %v", err, ErrUploadNotFound)
+		}
+	}
+
+	service.locks.Range(func(key, _ any) bool {
+		t.Errorf("lock of upload %v left behind", key)
+		return true
+	})
+}
@@ -2,6 +2,8 @@ package services
 
 import (
 	"errors"
+	"fmt"
+	"structured-notes/logger"
 	"structured-notes/models"
 	"structured-notes/repositories"
 	"structured-notes/types"
@@ -136,11 +138,16 @@ func (s *userService) UpdatePassword(id types.Snowflake, newPassword string) err
 	return s.userRepo.UpdatePassword(id, string(hash))
 }
 
+// DeleteUser deletes a user, their nodes go along through the database cascades and then their files.
+// Files that could not be removed are collected by MediaService.ReconcileMedia.
 func (s *userService) DeleteUser(id types.Snowflake, mediaService MediaService) error {
+	if err := s.userRepo.Delete(id); err != nil {
+		return err
+	}
 	if mediaService != nil {
 		if err := mediaService.DeleteAllFromUser(id); err != nil {
-			return err
+			logger.Error(fmt.Sprintf("Error removing the media files of user %d: %v", id, err))
 		}
 	}
-	return s.userRepo.Delete(id)
+	return nil
 }
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"structured-notes/models"
+	"structured-notes/permissions"
+	"structured-notes/repositories"
+	"structured-notes/types"
+	"structured-notes/utils"
+	"time"
+)
+
+// VersionRetention limits how many versions are kept per node
+type VersionRetention struct {
+	MaxCount int // 0: unlimited
+	MaxAge   int // days; 0: forever
+}
+
+type VersionService interface {
+	GetVersions(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeVersion, error)
+	GetVersion(nodeId, versionId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.NodeVersion, error)
+	DiffVersions(nodeId, versionId types.Snowflake, targetVersionId *types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (map[string]interface{}, error)
+	RestoreVersion(nodeId, versionId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error)
+}
+
+type versionService struct {
+	repos       *repositories.RepositoryManager
+	versionRepo repositories.VersionRepository
+	nodeRepo    repositories.NodeRepository
+	linkRepo    repositories.LinkRepository
+	snowflake   *utils.Snowflake
+}
+
+func NewVersionService(repos *repositories.RepositoryManager, snowflake *utils.Snowflake) VersionService {
+	return newVersionService(repos, snowflake)
+}
+
+func newVersionService(repos *repositories.RepositoryManager, snowflake *utils.Snowflake) *versionService {
+	return &versionService{
+		repos:       repos,
+		versionRepo: repos.Version,
+		nodeRepo:    repos.Node,
+		linkRepo:    repos.Link,
+		snowflake:   snowflake,
+	}
+}
+
+func (s *versionService) GetVersions(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) ([]*models.NodeVersion, error) {
+	if _, err := s.getReadableNode(nodeId, connectedUserId, connectedUserRole, authorizer); err != nil {
+		return nil, err
+	}
+	return s.versionRepo.GetByNode(nodeId)
+}
+
+func (s *versionService) GetVersion(nodeId, versionId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.NodeVersion, error) {
+	if _, err := s.getReadableNode(nodeId, connectedUserId, connectedUserRole, authorizer); err != nil {
+		return nil, err
+	}
+	return s.getNodeVersion(nodeId, versionId)
+}
+
+// DiffVersions compares the content of a version with another version, or with the current node when targetVersionId is nil
+func (s *versionService) DiffVersions(nodeId, versionId types.Snowflake, targetVersionId *types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (map[string]interface{}, error) {
+	dbNode, err := s.getReadableNode(nodeId, connectedUserId, connectedUserRole, authorizer)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := s.getNodeVersion(nodeId, versionId)
+	if err != nil {
+		return nil, err
+	}
+
+	targetContent := dbNode.Content
+	if targetVersionId != nil {
+		targetVersion, err := s.getNodeVersion(nodeId, *targetVersionId)
+		if err != nil {
+			return nil, err
+		}
+		targetContent = targetVersion.Content
+	}
+
+	return map[string]interface{}{
+		"from":    version.Id,
+		"to":      targetVersionId, // null: current content
+		"changes": utils.DiffLines(utils.StringValue(version.Content), utils.StringValue(targetContent)),
+	}, nil
+}
+
+func (s *versionService) RestoreVersion(nodeId, versionId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	var restoredNode *models.Node
+	// the current content is only saved as a version along with the restore that replaces it
+	err := s.repos.WithTransaction(func(repos *repositories.RepositoryManager) error {
+		var err error
+		restoredNode, err = newVersionService(repos, s.snowflake).restoreVersion(nodeId, versionId, connectedUserId, connectedUserRole, authorizer, retention)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restoredNode, nil
+}
+
+func (s *versionService) restoreVersion(nodeId, versionId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer, retention VersionRetention) (*models.Node, error) {
+	exists, err := s.nodeRepo.Lock(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errors.New("node not found")
+	}
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionUpdate)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed && (dbNode.Access < 2 || *dbNode.Accessibility != 3) {
+		return nil, errors.New("unauthorized")
+	}
+
+	version, err := s.getNodeVersion(nodeId, versionId)
+	if err != nil {
+		return nil, err
+	}
+
+	escapedHTMLContent := utils.EscapeHTML(version.ContentCompiled)
+	restoredNode := *dbNode
+	restoredNode.Name = version.Name
+	restoredNode.Content = version.Content
+	restoredNode.ContentCompiled = &escapedHTMLContent
+	restoredNode.UpdatedTimestamp = nextTimestamp(dbNode.UpdatedTimestamp)
+
+	updated, err := s.nodeRepo.UpdateIfUnchanged(&restoredNode, dbNode.UpdatedTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, &NodeConflictError{Current: dbNode}
+	}
+	// the current content becomes a version itself so that a restore can be undone
+	if err := saveNodeVersion(s.versionRepo, s.snowflake, dbNode, &restoredNode, connectedUserId, retention); err != nil {
+		return nil, err
+	}
+	if err := saveNodeLinks(s.linkRepo, &restoredNode); err != nil {
+		return nil, err
+	}
+	return &restoredNode, nil
+}
+
+func (s *versionService) getReadableNode(nodeId types.Snowflake, connectedUserId types.Snowflake, connectedUserRole permissions.UserRole, authorizer permissions.Authorizer) (*models.Node, error) {
+	dbNode, err := s.nodeRepo.GetByID(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if dbNode == nil {
+		return nil, errors.New("node not found")
+	}
+
+	allowed, _, err := authorizer.CanAccessNode(connectedUserId, connectedUserRole, dbNode, permissions.ActionRead)
+	if !allowed || err != nil {
+		return nil, errors.New("unauthorized")
+	}
+	return dbNode, nil
+}
+
+func (s *versionService) getNodeVersion(nodeId, versionId types.Snowflake) (*models.NodeVersion, error) {
+	version, err := s.versionRepo.GetByID(versionId)
+	if err != nil {
+		return nil, err
+	}
+	if version == nil || version.NodeId != nodeId {
+		return nil, errors.New("version not found")
+	}
+	return version, nil
+}
+
+// saveNodeVersion stores the content of dbNode as a version before it gets replaced by updatedNode.
+// Nothing is stored when neither the name nor the content changes.
+func saveNodeVersion(versionRepo repositories.VersionRepository, snowflake *utils.Snowflake, dbNode, updatedNode *models.Node, editorId types.Snowflake, retention VersionRetention) error {
+	if dbNode.Name == updatedNode.Name && utils.StringValue(dbNode.Content) == utils.StringValue(updatedNode.Content) {
+		return nil
+	}
+
+	version := &models.NodeVersion{
+		Id:               snowflake.Generate(),
+		NodeId:           dbNode.Id,
+		UserId:           &editorId,
+		Name:             dbNode.Name,
+		Content:          dbNode.Content,
+		ContentCompiled:  dbNode.ContentCompiled,
+		CreatedTimestamp: dbNode.UpdatedTimestamp,
+	}
+	if err := versionRepo.Create(version); err != nil {
+		return err
+	}
+
+	var olderThan int64
+	if retention.MaxAge > 0 {
+		olderThan = time.Now().AddDate(0, 0, -retention.MaxAge).UnixMilli()
+	}
+	return versionRepo.Prune(dbNode.Id, retention.MaxCount, olderThan)
+}
@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config is the [Media.S3] configuration, credentials are read from the environment
+type S3Config struct {
+	Endpoint  string // e.g. https://s3.eu-west-3.amazonaws.com or http://localhost:9000
+	Region    string
+	Bucket    string
+	Prefix    string // prepended to every key
+	PathStyle bool   // bucket in the path instead of the host name, required by most S3-compatible servers
+}
+
+// S3Storage stores objects in a bucket of an S3-compatible server.
+// Requests are signed with AWS Signature Version 4.
+type S3Storage struct {
+	config    S3Config
+	endpoint  *url.URL
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+func NewS3Storage(config S3Config, accessKey, secretKey string) (*S3Storage, error) {
+	if config.Bucket == "" {
+		return nil, errors.New("no S3 bucket configured")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("S3 credentials are missing")
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://s3." + config.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", config.Endpoint)
+	}
+	config.Prefix = strings.Trim(config.Prefix, "/")
+
+	return &S3Storage{
+		config:    config,
+		endpoint:  endpoint,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		client:    &http.Client{Transport: newS3Transport()},
+	}, nil
+}
+
+// newS3Transport bounds the wait for the server, not the transfer: no client timeout cuts off
+// the upload or download of a large media, whose body keeps moving as long as the peers do
+func newS3Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 10 * time.Second
+	transport.ResponseHeaderTimeout = 30 * time.Second
+	transport.ExpectContinueTimeout = time.Second
+	return transport
+}
+
+func (s *S3Storage) objectKey(key string) string {
+	if s.config.Prefix == "" {
+		return key
+	}
+	return s.config.Prefix + "/" + key
+}
+
+// objectURL returns the URL of an object, an empty key addresses the bucket
+func (s *S3Storage) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path = "/" + s.config.Bucket + "/" + key
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+func (s *S3Storage) Put(key string, r io.Reader, size int64) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(s.objectKey(key), nil).String(), r)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Get(key string) (io.ReadCloser, *ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(s.objectKey(key), nil).String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	info := objectInfo(key, resp)
+	return &s3Object{storage: s, key: key, etag: resp.Header.Get("ETag"), size: info.Size, body: resp.Body}, info, nil
+}
+
+// s3Object reads an object, seeking reopens it at the new offset with a range request.
+// Ranges ask for the version first read, a replaced object fails instead of mixing contents.
+type s3Object struct {
+	storage *S3Storage
+	key     string
+	etag    string
+	size    int64
+	body    io.ReadCloser
+	bodyPos int64 // offset body reads next
+	pos     int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil || o.bodyPos != o.pos {
+		if o.body != nil {
+			o.body.Close()
+			o.body = nil
+		}
+		req, err := http.NewRequest(http.MethodGet, o.storage.objectURL(o.storage.objectKey(o.key), nil).String(), nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.pos))
+		if o.etag != "" {
+			req.Header.Set("If-Match", o.etag)
+		}
+		resp, err := o.storage.do(req)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return 0, fmt.Errorf("s3 error %d: range not served", resp.StatusCode)
+		}
+		o.body, o.bodyPos = resp.Body, o.pos
+	}
+
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	o.bodyPos += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.pos
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	o.pos = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+func (s *S3Storage) Delete(key string) error {
+	key, err := cleanKey(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(s.objectKey(key), nil).String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (s *S3Storage) List(prefix string) ([]*ObjectInfo, error) {
+	objects := make([]*ObjectInfo, 0)
+	fullPrefix := s.objectKey(prefix)
+	if prefix == "" && s.config.Prefix != "" {
+		fullPrefix = s.config.Prefix + "/"
+	}
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {fullPrefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := http.NewRequest(http.MethodGet, s.objectURL("", query).String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object list: %w", err)
+		}
+
+		for _, content := range result.Contents {
+			key := content.Key
+			if s.config.Prefix != "" {
+				key = strings.TrimPrefix(key, s.config.Prefix+"/")
+			}
+			objects = append(objects, &ObjectInfo{Key: key, Size: content.Size, LastModified: content.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *S3Storage) Stat(key string) (*ObjectInfo, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(s.objectKey(key), nil).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return objectInfo(key, resp), nil
+}
+
+func objectInfo(key string, resp *http.Response) *ObjectInfo {
+	info := &ObjectInfo{Key: key, Size: resp.ContentLength}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = modified
+	}
+	return info
+}
+
+// do signs and sends a request, error responses are turned into errors
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var apiError struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(body, &apiError) == nil && apiError.Code != "" {
+		return nil, fmt.Errorf("s3 error %d %s: %s", resp.StatusCode, apiError.Code, apiError.Message)
+	}
+	return nil, fmt.Errorf("s3 error %d", resp.StatusCode)
+}
+
+const (
+	s3Algorithm      = "AWS4-HMAC-SHA256"
+	s3DateLayout     = "20060102"
+	s3DateTimeLayout = "20060102T150405Z"
+	// bodies are streamed, their hash is not part of the signature
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3EmptyPayload    = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// sign adds the Signature Version 4 Authorization header to a request
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	payloadHash := s3EmptyPayload
+	if req.Body != nil && req.Body != http.NoBody {
+		payloadHash = s3UnsignedPayload
+	}
+	req.Header.Set("X-Amz-Date", now.Format(s3DateTimeLayout))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// host and x-amz-* headers, plus content-type and range when present
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-type" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3CanonicalPath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format(s3DateLayout) + "/" + s.config.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		s3Algorithm,
+		now.Format(s3DateTimeLayout),
+		scope,
+		hexSHA256(canonicalRequest),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(s3DateLayout))
+	key = hmacSHA256(key, s.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3Algorithm, s.accessKey, scope, signedHeaders, signature))
+}
+
+func s3CanonicalPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return s3EscapePath(p)
+}
+
+// s3EscapePath encodes every byte but unreserved characters and slashes
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c == '/' || s3Unreserved(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3EscapeQuery(key)+"="+s3EscapeQuery(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3EscapeQuery(v string) string {
+	return strings.ReplaceAll(s3EscapePath(v), "/", "%2F")
+}
+
+func s3Unreserved(c byte) bool {
+	return 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~'
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
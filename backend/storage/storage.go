@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Storage holds the media files. Keys are slash separated paths such as "[userId]/[nodeId].ext".
+type Storage interface {
+	// Put stores size bytes of r under key, replacing any previous object
+	Put(key string, r io.Reader, size int64) error
+	// Get opens an object, the reader also implements io.ReadSeeker when the driver can seek, as both drivers here do
+	Get(key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete removes an object, deleting a missing object is not an error
+	Delete(key string) error
+	// List returns the objects whose key starts with prefix
+	List(prefix string) ([]*ObjectInfo, error)
+	Stat(key string) (*ObjectInfo, error)
+}
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Copy copies an object under another key
+func Copy(store Storage, srcKey, dstKey string) error {
+	src, info, err := store.Get(srcKey)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return store.Put(dstKey, src, info.Size)
+}
+
+// cleanKey rejects keys that are empty, absolute or escape the storage
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	cleaned := path.Clean(key)
+	if cleaned != key || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", ErrInvalidKey
+	}
+	return cleaned, nil
+}
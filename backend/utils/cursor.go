@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// EncodeCursor turns a pagination position into an opaque, URL safe string
+func EncodeCursor(position any) (string, error) {
+	encoded, err := json.Marshal(position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded), nil
+}
+
+// DecodeCursor is the reverse of EncodeCursor
+func DecodeCursor(cursor string, position any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return errors.New("invalid cursor")
+	}
+	if err := json.Unmarshal(decoded, position); err != nil {
+		return errors.New("invalid cursor")
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package utils
+
+import "strings"
+
+type DiffOp string
+
+const (
+	DiffEqual  DiffOp = "equal"
+	DiffInsert DiffOp = "insert"
+	DiffDelete DiffOp = "delete"
+)
+
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxDiffEdits bounds the edit distance searched by DiffLines, the search takes O(D²) memory and O((N+M)·D) time
+const maxDiffEdits = 1000
+
+// DiffLines computes a line based diff turning a into b (Myers algorithm).
+// Past maxDiffEdits changes, the lines between the common prefix and suffix are reported as replaced.
+func DiffLines(a, b string) []DiffLine {
+	from := splitLines(a)
+	to := splitLines(b)
+
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	result := make([]DiffLine, 0, len(from)+len(to)-prefix-suffix)
+	for _, line := range from[:prefix] {
+		result = append(result, DiffLine{Op: DiffEqual, Text: line})
+	}
+	middleFrom, middleTo := from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+	if middle, ok := diffMiddle(middleFrom, middleTo); ok {
+		result = append(result, middle...)
+	} else {
+		for _, line := range middleFrom {
+			result = append(result, DiffLine{Op: DiffDelete, Text: line})
+		}
+		for _, line := range middleTo {
+			result = append(result, DiffLine{Op: DiffInsert, Text: line})
+		}
+	}
+	for _, line := range from[len(from)-suffix:] {
+		result = append(result, DiffLine{Op: DiffEqual, Text: line})
+	}
+	return result
+}
+
+// diffMiddle runs the Myers search, it gives up past maxDiffEdits
+func diffMiddle(from, to []string) ([]DiffLine, bool) {
+	n, m := len(from), len(to)
+	maxEdits := min(n+m, maxDiffEdits)
+	offset := maxEdits + 1
+
+	// trace keeps the furthest reaching paths of every edit distance to backtrack the script,
+	// trace[d] holds the diagonals -(d-1)..d-1 reached after d-1 edits, the only ones step d reads
+	v := make([]int, 2*maxEdits+3)
+	trace := make([][]int, 0)
+	found := false
+	for d := 0; d <= maxEdits && !found; d++ {
+		if d == 0 {
+			trace = append(trace, nil)
+		} else {
+			snapshot := make([]int, 2*d-1)
+			copy(snapshot, v[offset-d+1:offset+d])
+			trace = append(trace, snapshot)
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && from[x] == to[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	result := make([]DiffLine, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		prevX, prevY := 0, 0
+		if d > 0 {
+			previous := trace[d]
+			reached := func(k int) int { return previous[k+d-1] }
+			k := x - y
+
+			var prevK int
+			if k == -d || (k != d && reached(k-1) < reached(k+1)) {
+				prevK = k + 1
+			} else {
+				prevK = k - 1
+			}
+			prevX = reached(prevK)
+			prevY = prevX - prevK
+		}
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			result = append(result, DiffLine{Op: DiffEqual, Text: from[x]})
+		}
+		if d > 0 {
+			if x == prevX {
+				result = append(result, DiffLine{Op: DiffInsert, Text: to[prevY]})
+			} else {
+				result = append(result, DiffLine{Op: DiffDelete, Text: from[prevX]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result, true
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// applyDiff rebuilds both sides of a diff
+func applyDiff(diff []DiffLine) (string, string) {
+	from, to := make([]string, 0), make([]string, 0)
+	for _, line := range diff {
+		if line.Op != DiffInsert {
+			from = append(from, line.Text)
+		}
+		if line.Op != DiffDelete {
+			to = append(to, line.Text)
+		}
+	}
+	return strings.Join(from, "\n"), strings.Join(to, "\n")
+}
+
+func countEdits(diff []DiffLine) int {
+	edits := 0
+	for _, line := range diff {
+		if line.Op != DiffEqual {
+			edits++
+		}
+	}
+	return edits
+}
+
+// lcsEdits is the shortest edit distance, from the longest common subsequence
+func lcsEdits(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	return len(a) + len(b) - 2*lengths[0][0]
+}
+
+func randomLines(random *rand.Rand, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = strconv.Itoa(random.Intn(4))
+	}
+	return lines
+}
+
+func TestDiffLinesIsShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		a, b := randomLines(random, random.Intn(20)), randomLines(random, random.Intn(20))
+		diff := DiffLines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+
+		gotA, gotB := applyDiff(diff)
+		if gotA != strings.Join(a, "\n") || gotB != strings.Join(b, "\n") {
+			t.Fatalf("DiffLines(%q, %q) rebuilds %q, %q", a, b, gotA, gotB)
+		}
+		if got, want := countEdits(diff), lcsEdits(splitLines(strings.Join(a, "\n")), splitLines(strings.Join(b, "\n"))); got != want {
+			t.Fatalf("DiffLines(%q, %q) has %d edits, want %d", a, b, got, want)
+		}
+	}
+}
+
+func TestDiffLinesFallsBackPastMaxEdits(t *testing.T) {
+	from, to := make([]string, 0), make([]string, 0)
+	for i := 0; i < maxDiffEdits; i++ {
+		from = append(from, "a"+strconv.Itoa(i))
+		to = append(to, "b"+strconv.Itoa(i))
+	}
+	a := "first\n" + strings.Join(from, "\n") + "\nlast"
+	b := "first\n" + strings.Join(to, "\n") + "\nlast"
+
+	diff := DiffLines(a, b)
+	if gotA, gotB := applyDiff(diff); gotA != a || gotB != b {
+		t.Fatal("the diff does not rebuild its inputs")
+	}
+	if diff[0].Op != DiffEqual || diff[len(diff)-1].Op != DiffEqual {
+		t.Errorf("common prefix and suffix not kept: %v, %v", diff[0], diff[len(diff)-1])
+	}
+	if diff[1].Op != DiffDelete || diff[len(diff)-2].Op != DiffInsert {
+		t.Errorf("middle not replaced: %v, %v", diff[1], diff[len(diff)-2])
+	}
+}
@@ -0,0 +1,204 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+var ErrInvalidJPEG = errors.New("invalid JPEG file")
+
+const (
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegAPP0 = 0xE0
+	jpegAPP1 = 0xE1 // Exif, XMP
+	jpegAPPD = 0xED // Photoshop, IPTC
+	jpegCOM  = 0xFE
+
+	exifOrientationTag = 0x0112
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+// StripJPEGMetadata removes the Exif (GPS position, camera, dates...), XMP, IPTC and comment segments of a JPEG file.
+// The image data is kept as is. The orientation is the only Exif field kept, so that viewers still turn photos upright.
+func StripJPEGMetadata(content []byte) ([]byte, error) {
+	if len(content) < 4 || content[0] != 0xFF || content[1] != jpegSOI {
+		return nil, ErrInvalidJPEG
+	}
+
+	orientation := JPEGOrientation(content)
+	out := bytes.NewBuffer(make([]byte, 0, len(content)))
+	out.Write(content[:2])
+
+	first := true
+	pos := 2
+	for {
+		// markers may be preceded by fill bytes
+		for pos < len(content) && content[pos] == 0xFF && pos+1 < len(content) && content[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+1 >= len(content) || content[pos] != 0xFF {
+			return nil, ErrInvalidJPEG
+		}
+		marker := content[pos+1]
+
+		// the compressed data and whatever follows it is copied as is
+		if marker == jpegSOS || marker == jpegEOI {
+			if first && orientation > 1 {
+				out.Write(orientationSegment(orientation))
+			}
+			out.Write(content[pos:])
+			return out.Bytes(), nil
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(content[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(content) {
+			return nil, ErrInvalidJPEG
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(content[pos+2:]))
+		if end > len(content) || end < pos+4 {
+			return nil, ErrInvalidJPEG
+		}
+
+		// JFIF requires its APP0 segment first, the orientation goes right after it
+		if first && marker != jpegAPP0 && orientation > 1 {
+			out.Write(orientationSegment(orientation))
+		}
+		if marker != jpegAPP1 && marker != jpegAPPD && marker != jpegCOM {
+			out.Write(content[pos:end])
+		}
+		if first && marker == jpegAPP0 && orientation > 1 {
+			out.Write(orientationSegment(orientation))
+		}
+		first = false
+		pos = end
+	}
+}
+
+// JPEGOrientation returns the Exif orientation of a JPEG file, 1 (upright) when it has none
+func JPEGOrientation(content []byte) int {
+	pos := 2
+	for pos+4 <= len(content) && content[pos] == 0xFF {
+		marker := content[pos+1]
+		if marker == jpegSOS || marker == jpegEOI {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+		// the length counts its own two bytes
+		end := pos + 2 + int(binary.BigEndian.Uint16(content[pos+2:]))
+		if end > len(content) || end < pos+4 {
+			break
+		}
+		if marker == jpegAPP1 && bytes.HasPrefix(content[pos+4:end], exifHeader) {
+			if orientation := exifOrientation(content[pos+4+len(exifHeader) : end]); orientation != 0 {
+				return orientation
+			}
+		}
+		pos = end
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of a TIFF structure, 0 when there is none
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == exifOrientationTag {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment builds an APP1 Exif segment holding only an orientation tag
+func orientationSegment(orientation int) []byte {
+	tiff := []byte{
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08, // big endian, first IFD at 8
+		0x00, 0x01, // one entry
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, byte(orientation >> 8), byte(orientation), 0x00, 0x00, // SHORT
+		0x00, 0x00, 0x00, 0x00, // no next IFD
+	}
+	segment := []byte{0xFF, jpegAPP1, 0, 0}
+	segment = append(segment, exifHeader...)
+	segment = append(segment, tiff...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)-2))
+	return segment
+}
+
+// OrientImage turns an image upright according to an Exif orientation
+func OrientImage(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	src := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// orientations 5 to 8 swap the sides
+	dstWidth, dstHeight := width, height
+	if orientation >= 5 {
+		dstWidth, dstHeight = height, width
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < dstHeight; y++ {
+		for x := 0; x < dstWidth; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = width-1-x, y
+			case 3: // upside down
+				sx, sy = width-1-x, height-1-y
+			case 4: // upside down, mirrored
+				sx, sy = x, height-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // turned left, needs a quarter turn clockwise
+				sx, sy = y, height-1-x
+			case 7: // transversed
+				sx, sy = width-1-y, height-1-x
+			case 8: // turned right, needs a quarter turn counterclockwise
+				sx, sy = width-1-y, x
+			}
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment with a little endian TIFF structure holding an orientation tag
+func exifSegment(orientation byte) []byte {
+	tiff := []byte{
+		'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00,
+		0x01, 0x00,
+		0x12, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, orientation, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	payload := append(append([]byte{}, exifHeader...), tiff...)
+	return append([]byte{0xFF, jpegAPP1, 0x00, byte(len(payload) + 2)}, payload...)
+}
+
+func jpegFile(segments ...[]byte) []byte {
+	content := []byte{0xFF, jpegSOI}
+	for _, segment := range segments {
+		content = append(content, segment...)
+	}
+	return content
+}
+
+var (
+	jfifSegment = []byte{0xFF, jpegAPP0, 0x00, 0x07, 'J', 'F', 'I', 'F', 0x00}
+	comSegment  = []byte{0xFF, jpegCOM, 0x00, 0x05, 'a', 'b', 'c'}
+	scanData    = []byte{0xFF, jpegSOS, 0x00, 0x02, 0x12, 0x34, 0xFF, jpegEOI}
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    int
+	}{
+		{"no segments", jpegFile(scanData), 1},
+		{"exif", jpegFile(jfifSegment, exifSegment(6), scanData), 6},
+		{"out of range", jpegFile(exifSegment(9), scanData), 1},
+		{"restart marker", jpegFile([]byte{0xFF, 0xD0}, exifSegment(3), scanData), 3},
+		{"zero length", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x00}, scanData), 1},
+		{"length of one", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x01}, scanData), 1},
+		{"truncated segment", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x40, 'E', 'x'}), 1},
+		{"truncated length", jpegFile([]byte{0xFF, jpegAPP1, 0x00}), 1},
+		{"truncated tiff", jpegFile(exifSegment(6)[:20]), 1},
+		{"empty", nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := JPEGOrientation(test.content); got != test.want {
+				t.Errorf("JPEGOrientation = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStripJPEGMetadata(t *testing.T) {
+	tests := []struct {
+		name    string
+		content []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"nothing to strip", jpegFile(jfifSegment, scanData), jpegFile(jfifSegment, scanData), false},
+		{"comment and exif", jpegFile(jfifSegment, comSegment, exifSegment(1), scanData), jpegFile(jfifSegment, scanData), false},
+		{"orientation kept after JFIF", jpegFile(jfifSegment, exifSegment(6), scanData), jpegFile(jfifSegment, orientationSegment(6), scanData), false},
+		{"orientation kept first", jpegFile(comSegment, exifSegment(8), scanData), jpegFile(orientationSegment(8), scanData), false},
+		{"fill bytes dropped", jpegFile([]byte{0xFF}, comSegment, scanData), jpegFile(scanData), false},
+		{"zero length", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x00}, scanData), nil, true},
+		{"length of one", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x01}, scanData), nil, true},
+		{"truncated segment", jpegFile([]byte{0xFF, jpegAPP1, 0x00, 0x40, 'E', 'x'}), nil, true},
+		{"truncated length", jpegFile([]byte{0xFF, jpegAPP1, 0x00}), nil, true},
+		{"no scan", jpegFile(jfifSegment), nil, true},
+		{"not a marker", jpegFile([]byte{0x00, 0x01}), nil, true},
+		{"not a JPEG", []byte("\x89PNG"), nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := StripJPEGMetadata(test.content)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidJPEG) {
+					t.Errorf("StripJPEGMetadata error = %v, want %v", err, ErrInvalidJPEG)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("StripJPEGMetadata: %v", err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Errorf("StripJPEGMetadata = % X, want % X", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	// decoders registered for image.Decode, GIFs decode to their first frame
+	_ "image/gif"
+
+	_ "golang.org/x/image/webp"
+)
+
+// MaxImagePixels bounds the images decoded by the server, a small file can declare huge dimensions
+const MaxImagePixels = 40_000_000
+
+var ErrImageTooLarge = errors.New("image dimensions exceed the limit")
+
+// DecodeImage decodes a PNG, JPEG, GIF or WebP image and returns its format.
+// The dimensions are checked before the pixels are allocated, JPEG photos are turned upright.
+func DecodeImage(content []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxImagePixels {
+		return nil, "", ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	if format == "jpeg" {
+		img = OrientImage(img, JPEGOrientation(content))
+	}
+	return img, format, nil
+}
+
+// CropSquare keeps the largest centered square of an image
+func CropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Point{X: x, Y: y}, draw.Src)
+	return square
+}
+
+// ResizeImage scales an image to width x height.
+// Every target pixel averages the source pixels it covers, which keeps downscaled images smooth.
+func ResizeImage(img image.Image, width, height int) *image.RGBA {
+	src, ok := img.(*image.RGBA)
+	if !ok || src.Bounds().Min != (image.Point{}) {
+		src = image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// source columns and rows covered by each target column and row
+	spans := func(srcSize, size int) [][2]int {
+		result := make([][2]int, size)
+		for i := range result {
+			from := i * srcSize / size
+			to := ((i + 1) * srcSize) / size
+			if to <= from {
+				to = from + 1
+			}
+			result[i] = [2]int{from, min(to, srcSize)}
+		}
+		return result
+	}
+	columns := spans(srcWidth, width)
+	rows := spans(srcHeight, height)
+
+	for y, row := range rows {
+		for x, column := range columns {
+			var r, g, b, a, count int
+			for sy := row[0]; sy < row[1]; sy++ {
+				offset := sy*src.Stride + column[0]*4
+				for sx := column[0]; sx < column[1]; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					count++
+				}
+			}
+			// the pixels are alpha premultiplied, their plain average is the right blend
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / count)
+			dst.Pix[i+1] = uint8(g / count)
+			dst.Pix[i+2] = uint8(b / count)
+			dst.Pix[i+3] = uint8(a / count)
+		}
+	}
+	return dst
+}
+
+// EncodeJPEG encodes an image without any metadata, transparent pixels are laid over white
+func EncodeJPEG(img *image.RGBA, quality int) ([]byte, error) {
+	opaque := image.NewRGBA(img.Bounds())
+	for i := 0; i < len(img.Pix); i += 4 {
+		transparency := 255 - img.Pix[i+3]
+		opaque.Pix[i] = img.Pix[i] + transparency
+		opaque.Pix[i+1] = img.Pix[i+1] + transparency
+		opaque.Pix[i+2] = img.Pix[i+2] + transparency
+		opaque.Pix[i+3] = 255
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodePNG encodes an image without any metadata
+func EncodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"testing"
+)
+
+// webpPixel is a lossless 1x1 WebP image
+var webpPixel = []byte("RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00")
+
+func TestDecodeImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	pngContent, err := EncodePNG(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpegContent, err := EncodeJPEG(img, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gifContent bytes.Buffer
+	if err := gif.Encode(&gifContent, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		format  string
+		size    image.Point
+	}{
+		{"png", pngContent, "png", image.Pt(3, 2)},
+		{"jpeg", jpegContent, "jpeg", image.Pt(3, 2)},
+		{"gif", gifContent.Bytes(), "gif", image.Pt(3, 2)},
+		{"webp", webpPixel, "webp", image.Pt(1, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decoded, format, err := DecodeImage(test.content)
+			if err != nil {
+				t.Fatalf("DecodeImage: %v", err)
+			}
+			if format != test.format || decoded.Bounds().Size() != test.size {
+				t.Errorf("DecodeImage = %s %v, want %s %v", format, decoded.Bounds().Size(), test.format, test.size)
+			}
+		})
+	}
+}
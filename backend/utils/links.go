@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"structured-notes/types"
+)
+
+var nodeLinkPatterns = []*regexp.Regexp{
+	// [[id]] or [[id|label]]
+	regexp.MustCompile(`\[\[\s*(\d+)\s*(?:\|[^\]]*)?\]\]`),
+	// /nodes/:userId/:id, as used by the API and the dashboard
+	regexp.MustCompile(`/nodes/[^/\s)"'\]]+/(\d+)`),
+}
+
+// ParseNodeLinks returns the ids of the nodes referenced in content, in order of appearance and without duplicates
+func ParseNodeLinks(content string) []types.Snowflake {
+	type match struct {
+		at int
+		id types.Snowflake
+	}
+	matches := make([]match, 0)
+	for _, pattern := range nodeLinkPatterns {
+		for _, m := range pattern.FindAllStringSubmatchIndex(content, -1) {
+			id, err := strconv.ParseUint(content[m[2]:m[3]], 10, 64)
+			if err != nil {
+				continue
+			}
+			matches = append(matches, match{at: m[0], id: types.Snowflake(id)})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int { return a.at - b.at })
+
+	ids := make([]types.Snowflake, 0, len(matches))
+	for _, m := range matches {
+		if !slices.Contains(ids, m.id) {
+			ids = append(ids, m.id)
+		}
+	}
+	return ids
+}
+
+// ReplaceNodeLinks rewrites the node ids referenced in content, ids missing from replacements are left as is
+func ReplaceNodeLinks(content string, replacements map[types.Snowflake]types.Snowflake) string {
+	for _, pattern := range nodeLinkPatterns {
+		var builder strings.Builder
+		last := 0
+		for _, m := range pattern.FindAllStringSubmatchIndex(content, -1) {
+			id, err := strconv.ParseUint(content[m[2]:m[3]], 10, 64)
+			if err != nil {
+				continue
+			}
+			replacement, ok := replacements[types.Snowflake(id)]
+			if !ok {
+				continue
+			}
+			builder.WriteString(content[last:m[2]])
+			builder.WriteString(strconv.FormatUint(uint64(replacement), 10))
+			last = m[3]
+		}
+		builder.WriteString(content[last:])
+		content = builder.String()
+	}
+	return content
+}
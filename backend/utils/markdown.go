@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CompileMarkdown renders the common subset of markdown to HTML: headings, paragraphs, fenced code,
+// block quotes, lists, rules, emphasis, code spans, links and images.
+// Documents edited in the app are compiled by the frontend, this covers content written elsewhere, e.g. imports.
+// The output still goes through EscapeHTML when stored.
+func CompileMarkdown(content string) string {
+	content = strings.NewReplacer("\r\n", "\n", "\x00", "").Replace(content) // NUL marks the spans set aside, see compileInline
+	lines := strings.Split(content, "\n")
+	var out strings.Builder
+	compileBlocks(&out, lines)
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+var (
+	markdownHeading     = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	markdownRule        = regexp.MustCompile(`^\s{0,3}(?:(?:-\s*){3,}|(?:\*\s*){3,}|(?:_\s*){3,})$`)
+	markdownBullet      = regexp.MustCompile(`^\s{0,3}[-*+]\s+(.*)$`)
+	markdownOrdered     = regexp.MustCompile(`^\s{0,3}\d{1,9}[.)]\s+(.*)$`)
+	markdownCodeSpan    = regexp.MustCompile("`([^`]+)`")
+	markdownImage       = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	markdownLink        = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+	markdownStrong      = regexp.MustCompile(`\*\*(\S(?:.*?\S)?)\*\*|__(\S(?:.*?\S)?)__`)
+	markdownEmphasis    = regexp.MustCompile(`(^|[^\w*])[*_](\S(?:[^*_]*?\S)?)[*_]`)
+	markdownPlaceholder = regexp.MustCompile("\x00(\\d+)\x00")
+)
+
+func compileBlocks(out *strings.Builder, lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			i++
+
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			fence := trimmed[:3]
+			language := strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1]))
+			i++
+			start := i
+			for i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence) {
+				i++
+			}
+			code := html.EscapeString(strings.Join(lines[start:i], "\n"))
+			if language != "" {
+				out.WriteString(`<pre><code class="language-` + html.EscapeString(strings.Fields(language)[0]) + `">` + code + "</code></pre>\n")
+			} else {
+				out.WriteString("<pre><code>" + code + "</code></pre>\n")
+			}
+			i++ // closing fence
+
+		case markdownHeading.MatchString(trimmed):
+			match := markdownHeading.FindStringSubmatch(trimmed)
+			level := strconv.Itoa(len(match[1]))
+			out.WriteString("<h" + level + ">" + compileInline(match[2]) + "</h" + level + ">\n")
+			i++
+
+		case markdownRule.MatchString(line):
+			out.WriteString("<hr>\n")
+			i++
+
+		case strings.HasPrefix(trimmed, ">"):
+			quoted := make([]string, 0)
+			for i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">") {
+				quote := strings.TrimPrefix(strings.TrimSpace(lines[i]), ">")
+				quoted = append(quoted, strings.TrimPrefix(quote, " "))
+				i++
+			}
+			out.WriteString("<blockquote>\n")
+			compileBlocks(out, quoted)
+			out.WriteString("</blockquote>\n")
+
+		case markdownBullet.MatchString(line), markdownOrdered.MatchString(line):
+			item, tag := markdownBullet, "ul"
+			if !markdownBullet.MatchString(line) {
+				item, tag = markdownOrdered, "ol"
+			}
+			out.WriteString("<" + tag + ">\n")
+			for i < len(lines) && item.MatchString(lines[i]) && !markdownRule.MatchString(lines[i]) {
+				out.WriteString("<li>" + compileInline(item.FindStringSubmatch(lines[i])[1]) + "</li>\n")
+				i++
+			}
+			out.WriteString("</" + tag + ">\n")
+
+		default:
+			paragraph := make([]string, 0)
+			for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !startsBlock(lines[i]) {
+				paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+				i++
+			}
+			if len(paragraph) == 0 { // a block start the cases above did not take
+				paragraph = append(paragraph, trimmed)
+				i++
+			}
+			out.WriteString("<p>" + compileInline(strings.Join(paragraph, "\n")) + "</p>\n")
+		}
+	}
+}
+
+// startsBlock tells whether a line ends the paragraph before it
+func startsBlock(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") || strings.HasPrefix(trimmed, ">") ||
+		markdownHeading.MatchString(trimmed) || markdownRule.MatchString(line) ||
+		markdownBullet.MatchString(line) || markdownOrdered.MatchString(line)
+}
+
+// compileInline renders the spans of a block, code spans, images and links are set aside first so their content is not formatted
+func compileInline(text string) string {
+	spans := make([]string, 0)
+	keep := func(rendered string) string {
+		spans = append(spans, rendered)
+		return "\x00" + strconv.Itoa(len(spans)-1) + "\x00"
+	}
+
+	text = markdownCodeSpan.ReplaceAllStringFunc(text, func(match string) string {
+		code := markdownCodeSpan.FindStringSubmatch(match)[1]
+		return keep("<code>" + html.EscapeString(code) + "</code>")
+	})
+	text = markdownImage.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownImage.FindStringSubmatch(match)
+		return keep(`<img src="` + html.EscapeString(parts[2]) + `" alt="` + html.EscapeString(parts[1]) + `">`)
+	})
+	text = markdownLink.ReplaceAllStringFunc(text, func(match string) string {
+		parts := markdownLink.FindStringSubmatch(match)
+		return keep(`<a href="` + html.EscapeString(parts[2]) + `">` + formatInline(html.EscapeString(parts[1])) + "</a>")
+	})
+
+	text = formatInline(html.EscapeString(text))
+	text = strings.ReplaceAll(text, "\n", "<br>\n")
+	var restore func(text string) string
+	restore = func(text string) string { // links may hold code spans or images, always set aside before them
+		return markdownPlaceholder.ReplaceAllStringFunc(text, func(match string) string {
+			index, _ := strconv.Atoi(markdownPlaceholder.FindStringSubmatch(match)[1])
+			return restore(spans[index])
+		})
+	}
+	return restore(text)
+}
+
+func formatInline(text string) string {
+	text = markdownStrong.ReplaceAllString(text, "<strong>$1$2</strong>")
+	return markdownEmphasis.ReplaceAllString(text, "$1<em>$2</em>")
+}
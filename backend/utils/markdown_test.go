@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+func TestCompileMarkdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"heading", "# Title #", "<h1>Title</h1>"},
+		{"paragraph", "one\ntwo\n\nthree", "<p>one<br>\ntwo</p>\n<p>three</p>"},
+		{"emphasis", "**bold**, __bold__ and *em*", "<p><strong>bold</strong>, <strong>bold</strong> and <em>em</em></p>"},
+		{"escaped", "a < b & <script>", "<p>a &lt; b &amp; &lt;script&gt;</p>"},
+		{"code span kept", "`**a** <b>`", "<p><code>**a** &lt;b&gt;</code></p>"},
+		{"fenced code", "```go\nx := 1 < 2\n```", "<pre><code class=\"language-go\">x := 1 &lt; 2</code></pre>"},
+		{"unclosed fence", "```\ncode", "<pre><code>code</code></pre>"},
+		{"image", "![alt](/media/1/2.png)", "<p><img src=\"/media/1/2.png\" alt=\"alt\"></p>"},
+		{"link with code", "[`x`](https://example.com \"title\")", "<p><a href=\"https://example.com\"><code>x</code></a></p>"},
+		{"lists", "- a\n- b\n1. c", "<ul>\n<li>a</li>\n<li>b</li>\n</ul>\n<ol>\n<li>c</li>\n</ol>"},
+		{"rule", "text\n---\n", "<p>text</p>\n<hr>"},
+		{"quote", "> # q\n> text", "<blockquote>\n<h1>q</h1>\n<p>text</p>\n</blockquote>"},
+		{"placeholder in input", "\x000\x00 a", "<p>0 a</p>"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := CompileMarkdown(test.content); got != test.want {
+				t.Errorf("CompileMarkdown(%q) = %q, want %q", test.content, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package utils
+
+// MergePatch applies a JSON merge patch (RFC 7396) to a decoded JSON document.
+// Objects are merged recursively, null removes a member and any other value replaces the target.
+func MergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+
+	merged := make(map[string]interface{}, len(targetObject))
+	for key, value := range targetObject {
+		merged[key] = value
+	}
+	for key, value := range patchObject {
+		if value == nil {
+			delete(merged, key)
+		} else {
+			merged[key] = MergePatch(merged[key], value)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,180 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownMediaType  = errors.New("file type not supported")
+	ErrMediaTypeMismatch = errors.New("file content does not match its extension")
+)
+
+// mediaType is an accepted file extension, the type it is stored as and the sniffed types agreeing with it
+type mediaType struct {
+	mimeType string
+	sniffed  []string
+}
+
+const (
+	mimeOLE2     = "application/x-ole-storage" // legacy office documents
+	mimeOOXMLDoc = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeOOXMLXls = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	mimeOOXMLPpt = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	mimeODT      = "application/vnd.oasis.opendocument.text"
+	mimeODS      = "application/vnd.oasis.opendocument.spreadsheet"
+	mimeODP      = "application/vnd.oasis.opendocument.presentation"
+)
+
+var mediaTypes = map[string]mediaType{
+	// images
+	".png":  {"image/png", []string{"image/png"}},
+	".jpg":  {"image/jpeg", []string{"image/jpeg"}},
+	".jpeg": {"image/jpeg", []string{"image/jpeg"}},
+	".gif":  {"image/gif", []string{"image/gif"}},
+	".webp": {"image/webp", []string{"image/webp"}},
+	// documents
+	".pdf":  {"application/pdf", []string{"application/pdf"}},
+	".doc":  {"application/msword", []string{mimeOLE2}},
+	".xls":  {"application/vnd.ms-excel", []string{mimeOLE2}},
+	".ppt":  {"application/vnd.ms-powerpoint", []string{mimeOLE2}},
+	".docx": {mimeOOXMLDoc, []string{mimeOOXMLDoc}},
+	".xlsx": {mimeOOXMLXls, []string{mimeOOXMLXls}},
+	".pptx": {mimeOOXMLPpt, []string{mimeOOXMLPpt}},
+	".odt":  {mimeODT, []string{mimeODT}},
+	".ods":  {mimeODS, []string{mimeODS}},
+	".odp":  {mimeODP, []string{mimeODP}},
+	// audio
+	".mp3":  {"audio/mpeg", []string{"audio/mpeg"}},
+	".wav":  {"audio/wav", []string{"audio/wave"}},
+	".ogg":  {"audio/ogg", []string{"application/ogg"}},
+	".oga":  {"audio/ogg", []string{"application/ogg"}},
+	".flac": {"audio/flac", []string{"audio/flac"}},
+	".m4a":  {"audio/mp4", []string{"audio/mp4", "video/mp4"}},
+	// video
+	".mp4":  {"video/mp4", []string{"video/mp4"}},
+	".m4v":  {"video/mp4", []string{"video/mp4"}},
+	".webm": {"video/webm", []string{"video/webm"}},
+	".ogv":  {"video/ogg", []string{"application/ogg"}},
+	".mov":  {"video/quicktime", []string{"video/quicktime", "video/mp4"}},
+	".avi":  {"video/x-msvideo", []string{"video/avi"}},
+}
+
+// MediaTypeOf returns the type a file is stored as from its extension alone
+func MediaTypeOf(filename string) (string, bool) {
+	accepted, ok := mediaTypes[strings.ToLower(filepath.Ext(filename))]
+	return accepted.mimeType, ok
+}
+
+// DetectMediaType returns the type a file is stored as, from its extension and checked against its content.
+// The Content-Type sent by clients is not trusted.
+func DetectMediaType(filename string, content []byte) (string, error) {
+	return DetectMediaTypeAt(filename, bytes.NewReader(content), int64(len(content)))
+}
+
+// DetectMediaTypeAt is DetectMediaType for a file that is not loaded in memory
+func DetectMediaTypeAt(filename string, file io.ReaderAt, size int64) (string, error) {
+	accepted, ok := mediaTypes[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return "", ErrUnknownMediaType
+	}
+	if !slices.Contains(accepted.sniffed, SniffContentType(file, size)) {
+		return "", ErrMediaTypeMismatch
+	}
+	return accepted.mimeType, nil
+}
+
+// SniffContentType detects the type of a file from its bytes.
+// It extends http.DetectContentType with the office and media formats it leaves out.
+func SniffContentType(file io.ReaderAt, size int64) string {
+	// http.DetectContentType looks at 512 bytes at most
+	content := make([]byte, min(size, 512))
+	if _, err := file.ReadAt(content, 0); err != nil && err != io.EOF {
+		return "application/octet-stream"
+	}
+
+	sniffed, _, err := mime.ParseMediaType(http.DetectContentType(content))
+	if err != nil {
+		return "application/octet-stream"
+	}
+
+	switch sniffed {
+	case "application/octet-stream":
+		switch {
+		case bytes.HasPrefix(content, []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}):
+			return mimeOLE2
+		case bytes.HasPrefix(content, []byte("fLaC")):
+			return "audio/flac"
+		case len(content) >= 12 && string(content[4:8]) == "ftyp":
+			return sniffISOMedia(content)
+		// MP3 frames without an ID3 tag
+		case len(content) >= 2 && content[0] == 0xFF && content[1]&0xE0 == 0xE0:
+			return "audio/mpeg"
+		}
+	case "video/mp4":
+		return sniffISOMedia(content)
+	case "application/zip":
+		return sniffOfficeArchive(file, size)
+	}
+	return sniffed
+}
+
+// sniffISOMedia tells MP4 audio and QuickTime movies from MP4 videos by their major brand
+func sniffISOMedia(content []byte) string {
+	switch string(content[8:12]) {
+	case "M4A ", "M4B ":
+		return "audio/mp4"
+	case "qt  ":
+		return "video/quicktime"
+	}
+	return "video/mp4"
+}
+
+// sniffOfficeArchive tells OpenDocument and Office Open XML documents from other ZIP archives
+func sniffOfficeArchive(file io.ReaderAt, size int64) string {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return "application/zip"
+	}
+
+	isOOXML := false
+	parts := make(map[string]bool)
+	for _, file := range archive.File {
+		switch {
+		// OpenDocument stores its type, uncompressed, as the first entry
+		case file.Name == "mimetype":
+			reader, err := file.Open()
+			if err != nil {
+				return "application/zip"
+			}
+			mimeType, _ := io.ReadAll(io.LimitReader(reader, 128))
+			reader.Close()
+			if t := strings.TrimSpace(string(mimeType)); t == mimeODT || t == mimeODS || t == mimeODP {
+				return t
+			}
+		case file.Name == "[Content_Types].xml":
+			isOOXML = true
+		default:
+			if folder, _, ok := strings.Cut(file.Name, "/"); ok {
+				parts[folder] = true
+			}
+		}
+	}
+
+	switch {
+	case isOOXML && parts["word"]:
+		return mimeOOXMLDoc
+	case isOOXML && parts["xl"]:
+		return mimeOOXMLXls
+	case isOOXML && parts["ppt"]:
+		return mimeOOXMLPpt
+	}
+	return "application/zip"
+}
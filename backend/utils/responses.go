@@ -27,6 +27,16 @@ func Error(message string) gin.H {
 	}
 }
 
+// ErrorWithResult is an error response carrying a result, e.g. the current state of a resource on a conflict
+type ErrorWithResult struct {
+	Err    error
+	Result any
+}
+
+func (e *ErrorWithResult) Error() string {
+	return e.Err.Error()
+}
+
 // Note: response formatter wrapper works actually like a middleware
 // This function could be actually remade to a middleware
 // TODO: To test this approach later
@@ -39,6 +49,10 @@ func ResponseFormatter(f func(c *gin.Context) (int, any)) gin.HandlerFunc {
 				errors = append(errors, validationErrorToText(e))
 			}
 			c.JSON(code, Error(strings.Join(errors, " ")))
+		} else if err, ok := body.(*ErrorWithResult); ok {
+			response := Error(err.Error())
+			response["result"] = err.Result
+			c.JSON(code, response)
 		} else if err, ok := body.(error); ok {
 			c.JSON(code, Error(err.Error()))
 		} else {
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// Snippet returns an excerpt of text around the first match of terms.
+// The excerpt is HTML escaped and every match is wrapped in <mark>.
+func Snippet(text string, terms []string, radius int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	lowerTerms := make([][]rune, 0, len(terms))
+	for _, term := range terms {
+		if term != "" {
+			lowerTerms = append(lowerTerms, []rune(strings.ToLower(term)))
+		}
+	}
+
+	matchAt := func(i, end int) int {
+		longest := 0
+		for _, term := range lowerTerms {
+			if len(term) > longest && i+len(term) <= end && string(lower[i:i+len(term)]) == string(term) {
+				longest = len(term)
+			}
+		}
+		return longest
+	}
+
+	first := -1
+	for i := range lower {
+		if matchAt(i, len(lower)) > 0 {
+			first = i
+			break
+		}
+	}
+
+	start, end := 0, min(len(runes), 2*radius)
+	if first >= 0 {
+		start = max(0, first-radius)
+		end = min(len(runes), first+radius)
+	}
+
+	var snippet strings.Builder
+	if start > 0 {
+		snippet.WriteString("…")
+	}
+	plainStart := start
+	for i := start; i < end; {
+		if length := matchAt(i, end); length > 0 {
+			snippet.WriteString(html.EscapeString(string(runes[plainStart:i])))
+			snippet.WriteString("<mark>" + html.EscapeString(string(runes[i:i+length])) + "</mark>")
+			i += length
+			plainStart = i
+			continue
+		}
+		i++
+	}
+	snippet.WriteString(html.EscapeString(string(runes[plainStart:end])))
+	if end < len(runes) {
+		snippet.WriteString("…")
+	}
+	return snippet.String()
+}